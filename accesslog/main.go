@@ -0,0 +1,300 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package accesslog writes access log lines in the Common, Combined or JSON
+// formats, to files that can be reopened after being rotated.
+package accesslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is the format of access log lines.
+type Format int
+
+// Access log formats.
+const (
+	// Common Log Format.
+	Common Format = iota
+	// Combined Log Format, Common plus referer and user agent.
+	Combined
+	// One JSON object per line.
+	JSON
+)
+
+// ParseFormat returns the format with the given name: "common", "combined"
+// or "json".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "common":
+		return Common, nil
+	case "combined":
+		return Combined, nil
+	case "json":
+		return JSON, nil
+	}
+	return Common, fmt.Errorf("Unknown access log format %q.", name)
+}
+
+// ResponseWriter wraps a http.ResponseWriter and records the status and the
+// number of bytes of the response.
+type ResponseWriter struct {
+	http.ResponseWriter
+	// Status code, zero until the header is written.
+	Status int
+	// Bytes written to the body.
+	Size int64
+}
+
+// NewResponseWriter wraps w.
+func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
+	return &ResponseWriter{ResponseWriter: w}
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.Status == 0 {
+		w.Status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	if w.Status == 0 {
+		w.Status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.Size += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, if the wrapped writer does.
+func (w *ResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the wrapped writer does. Hijacked
+// connections are logged as switching protocols.
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("The connection can't be hijacked.")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && w.Status == 0 {
+		w.Status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Entry describes a request that was served.
+type Entry struct {
+	Time       time.Time
+	Duration   time.Duration
+	RemoteAddr string
+	User       string
+	Host       string
+	Method     string
+	URI        string
+	Proto      string
+	Status     int
+	Size       int64
+	Referer    string
+	UserAgent  string
+}
+
+// NewEntry describes a request and its response. The response writer must
+// have been used to serve the request.
+func NewEntry(req *http.Request, w *ResponseWriter, started time.Time) *Entry {
+	e := &Entry{
+		Time:       started,
+		Duration:   time.Since(started),
+		RemoteAddr: req.RemoteAddr,
+		Host:       req.Host,
+		Method:     req.Method,
+		URI:        req.RequestURI,
+		Proto:      req.Proto,
+		Status:     w.Status,
+		Size:       w.Size,
+		Referer:    req.Referer(),
+		UserAgent:  req.UserAgent(),
+	}
+	if host, _, err := net.SplitHostPort(e.RemoteAddr); err == nil {
+		e.RemoteAddr = host
+	}
+	if user, _, ok := req.BasicAuth(); ok {
+		e.User = user
+	}
+	if e.Status == 0 {
+		e.Status = http.StatusOK
+	}
+	return e
+}
+
+// jsonEntry is the JSON representation of an entry.
+type jsonEntry struct {
+	Time       string  `json:"time"`
+	Duration   float64 `json:"duration"`
+	RemoteAddr string  `json:"remote_addr"`
+	User       string  `json:"user,omitempty"`
+	Host       string  `json:"host"`
+	Method     string  `json:"method"`
+	URI        string  `json:"uri"`
+	Proto      string  `json:"proto"`
+	Status     int     `json:"status"`
+	Size       int64   `json:"size"`
+	Referer    string  `json:"referer,omitempty"`
+	UserAgent  string  `json:"user_agent,omitempty"`
+}
+
+// Line returns the entry as a log line in the given format, including the
+// trailing new line.
+func (e *Entry) Line(f Format) []byte {
+	if f == JSON {
+		buf, _ := json.Marshal(jsonEntry{
+			Time:       e.Time.Format(time.RFC3339),
+			Duration:   e.Duration.Seconds(),
+			RemoteAddr: e.RemoteAddr,
+			User:       e.User,
+			Host:       e.Host,
+			Method:     e.Method,
+			URI:        e.URI,
+			Proto:      e.Proto,
+			Status:     e.Status,
+			Size:       e.Size,
+			Referer:    e.Referer,
+			UserAgent:  e.UserAgent,
+		})
+		return append(buf, '\n')
+	}
+
+	size := "-"
+	if e.Size > 0 {
+		size = strconv.FormatInt(e.Size, 10)
+	}
+
+	line := fmt.Sprintf("%s - %s [%s] %s %d %s",
+		dash(e.RemoteAddr),
+		dash(e.User),
+		e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(e.Method+" "+e.URI+" "+e.Proto),
+		e.Status,
+		size,
+	)
+
+	if f == Combined {
+		line += " " + strconv.Quote(dash(e.Referer)) + " " + strconv.Quote(dash(e.UserAgent))
+	}
+
+	return []byte(line + "\n")
+}
+
+// dash returns "-" for empty values.
+func dash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// File is an access log file. It's safe for concurrent use.
+type File struct {
+	// Name of the file, empty for the standard output.
+	Name string
+	mu   sync.Mutex
+	fp   *os.File
+}
+
+// Open opens or creates a log file for appending. An empty name means the
+// standard output.
+func Open(name string) (*File, error) {
+	f := &File{Name: name}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the file, f.mu must be held or f must not be shared yet.
+func (f *File) open() error {
+	if f.Name == "" {
+		f.fp = os.Stdout
+	} else {
+		fp, err := os.OpenFile(f.Name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		f.fp = fp
+	}
+	return nil
+}
+
+// Write writes a log line.
+func (f *File) Write(line []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.fp == nil {
+		return 0, os.ErrClosed
+	}
+	return f.fp.Write(line)
+}
+
+// Reopen closes and opens the file again, so a file that was moved away by a
+// log rotation tool is created again.
+func (f *File) Reopen() error {
+	if f.Name == "" {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.close()
+	return f.open()
+}
+
+// Close closes the file. The standard output is never closed.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.close()
+}
+
+// close closes the file, f.mu must be held.
+func (f *File) close() error {
+	var err error
+	if f.fp != nil && f.fp != os.Stdout {
+		err = f.fp.Close()
+	}
+	f.fp = nil
+	return err
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package accesslog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEntry(t *testing.T) {
+	req := httptest.NewRequest("GET", "/docs?q=1", nil)
+	req.Header.Set("Referer", "http://example.org/")
+	req.Header.Set("User-Agent", `Test "agent"`)
+
+	rec := httptest.NewRecorder()
+	w := NewResponseWriter(rec)
+	http.Error(w, "Gone", http.StatusGone)
+
+	started := time.Date(2014, 1, 2, 3, 4, 5, 0, time.UTC)
+	e := NewEntry(req, w, started)
+
+	if e.Status != http.StatusGone || e.Size != int64(rec.Body.Len()) {
+		t.Fatalf("Unexpected status or size: %d %d", e.Status, e.Size)
+	}
+
+	common := `192.0.2.1 - - [02/Jan/2014:03:04:05 +0000] "GET /docs?q=1 HTTP/1.1" 410 5` + "\n"
+	if line := string(e.Line(Common)); line != common {
+		t.Fatalf("Unexpected common line: %q", line)
+	}
+
+	combined := strings.TrimSuffix(common, "\n") + ` "http://example.org/" "Test \"agent\""` + "\n"
+	if line := string(e.Line(Combined)); line != combined {
+		t.Fatalf("Unexpected combined line: %q", line)
+	}
+
+	if line := string(e.Line(JSON)); !strings.Contains(line, `"status":410`) || !strings.Contains(line, `"user_agent":"Test \"agent\""`) {
+		t.Fatalf("Unexpected JSON line: %q", line)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package cache is a least recently used cache for rendered pages. Entries
+// remember the files they were made from and are dropped when any of them
+// changes.
+package cache
+
+import (
+	"container/list"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Deps are the files an entry was made from.
+type Deps struct {
+	// Files that were read.
+	Files []string
+	// Directories that were listed. Changes to their children matter, and so do
+	// changes within child directories, which may appear or go away with them.
+	Dirs []string
+	// Directories where a change to any file matters.
+	Trees []string
+}
+
+// Add appends files to the list of files that were read.
+func (d *Deps) Add(files ...string) {
+	d.Files = append(d.Files, files...)
+}
+
+// Has returns true if a change to the given file affects an entry with these
+// dependencies.
+func (d *Deps) Has(file string) bool {
+	file = filepath.Clean(file)
+	for _, f := range d.Files {
+		if filepath.Clean(f) == file {
+			return true
+		}
+	}
+	parent := filepath.Dir(file)
+	for _, dir := range d.Dirs {
+		if dir = filepath.Clean(dir); dir == parent || dir == filepath.Dir(parent) {
+			return true
+		}
+	}
+	for _, dir := range d.Trees {
+		dir = filepath.Clean(dir)
+		if file == dir || strings.HasPrefix(file, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// entry is an element of the cache.
+type entry struct {
+	key   string
+	value interface{}
+	deps  *Deps
+}
+
+// Cache is a least recently used cache with a maximum number of entries. It's
+// safe for concurrent use.
+type Cache struct {
+	mu    sync.Mutex
+	max   int
+	ll    *list.List
+	items map[string]*list.Element
+	// Incremented by every invalidation.
+	gen uint64
+}
+
+// New creates a cache that holds up to max entries.
+func New(max int) *Cache {
+	return &Cache{
+		max:   max,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value of the given key, if present.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*entry).value, true
+	}
+	return nil, false
+}
+
+// Generation returns a number that changes whenever entries are invalidated.
+// Values made from files read after getting the generation can be stored with
+// Put.
+func (c *Cache) Generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// Put stores a value and the files it was made from. The value is discarded
+// if entries were invalidated after gen was obtained, since it could have been
+// made from files that changed meanwhile.
+func (c *Cache) Put(key string, value interface{}, deps *Deps, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if gen != c.gen || c.max <= 0 {
+		return
+	}
+
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		el.Value = &entry{key, value, deps}
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&entry{key, value, deps})
+
+	for c.ll.Len() > c.max {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Invalidate removes the entries that depend on the given file and returns
+// how many were removed.
+func (c *Cache) Invalidate(file string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+
+	n := 0
+	for el := c.ll.Front(); el != nil; {
+		next := el.Next()
+		if deps := el.Value.(*entry).deps; deps == nil || deps.Has(file) {
+			c.remove(el)
+			n++
+		}
+		el = next
+	}
+
+	return n
+}
+
+// Len returns the number of entries.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// remove deletes an element, c.mu must be held.
+func (c *Cache) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package cache
+
+import (
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	c := New(2)
+
+	c.Put("a", 1, nil, c.Generation())
+	c.Put("b", 2, nil, c.Generation())
+	c.Get("a")
+	c.Put("c", 3, nil, c.Generation())
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("Expecting b to be evicted.")
+	}
+
+	if v, ok := c.Get("a"); !ok || v.(int) != 1 {
+		t.Fatalf("Unexpected value for a: %v", v)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := New(10)
+
+	gen := c.Generation()
+
+	c.Put("/docs/intro", "intro", &Deps{
+		Files: []string{"/site/site.yaml"},
+		Dirs:  []string{"/site/content"},
+		Trees: []string{"/site/content/docs", "/site/templates"},
+	}, gen)
+
+	c.Put("/", "home", &Deps{
+		Files: []string{"/site/content/index.md"},
+	}, gen)
+
+	tests := []struct {
+		file    string
+		removed int
+	}{
+		{"/site/other/index.md", 0},
+		{"/site/content/other/images/logo.png", 0},
+		{"/site/content/docs/api/index.md", 1},
+		{"/site/content/index.md", 1},
+	}
+
+	for _, test := range tests {
+		if n := c.Invalidate(test.file); n != test.removed {
+			t.Fatalf("%s: expecting %d removed entries, got %d.", test.file, test.removed, n)
+		}
+	}
+
+	c.Put("/", "home", nil, gen)
+	if _, ok := c.Get("/"); ok {
+		t.Fatal("Expecting values of an old generation to be discarded.")
+	}
+}
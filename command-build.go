@@ -0,0 +1,95 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"menteslibres.net/gosexy/cli"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/host"
+)
+
+// Default output directory for "luminos build".
+const envBuildOutput = "./build"
+
+// Command line settings.
+var (
+	flagBuildOutput = flag.String("o", envBuildOutput, "Output directory for static builds.")
+	flagBuildFull   = flag.Bool("full", false, "Render all pages, even if they are up to date.")
+)
+
+// buildCommand is the structure that provides instructions for the "luminos
+// build" subcommand.
+type buildCommand struct {
+}
+
+// buildDirectory returns the name of the output directory for a host,
+// "example.org/docs" becomes "example.org_docs".
+func buildDirectory(name string) string {
+	return strings.NewReplacer("/", "_", ":", "_", "*", "_").Replace(strings.Trim(name, "/"))
+}
+
+// Execute renders every host into a static site.
+func (c *buildCommand) Execute() error {
+	*flagSettings = config.FindFile(*flagSettings)
+
+	conf, problems := config.LoadServerConfig(*flagSettings)
+	if err := problems.Err(); err != nil {
+		return fmt.Errorf("Error while reading settings file %s: %q", *flagSettings, err)
+	}
+
+	entries := conf.Hosts
+
+	for _, entry := range entries {
+		h, err := host.New(entry.Name, entry.Root)
+		if err != nil {
+			return fmt.Errorf("Failed to initialize host %s: %q", entry.Name, err)
+		}
+
+		outdir := filepath.Join(*flagBuildOutput, buildDirectory(entry.Name))
+
+		stats, err := h.Build(outdir, !*flagBuildFull)
+		h.Close()
+
+		if err != nil {
+			return fmt.Errorf("Failed to build host %s: %q", entry.Name, err)
+		}
+
+		fmt.Printf("%s -> %s: %d pages rendered, %d up to date, %d files copied.\n", entry.Name, outdir, stats.Rendered, stats.Skipped, stats.Copied)
+	}
+
+	return nil
+}
+
+func init() {
+	// Describing the "build" subcommand.
+	cli.Register("build", cli.Entry{
+		Name:        "build",
+		Description: "Renders all hosts into static sites.",
+		Arguments:   []string{"c", "o", "full"},
+		Command:     &buildCommand{},
+	})
+}
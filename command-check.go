@@ -0,0 +1,126 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"menteslibres.net/gosexy/cli"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/host"
+	"menteslibres.net/luminos/router"
+)
+
+// checkCommand is the structure that provides instructions for the "luminos
+// check" subcommand.
+type checkCommand struct {
+}
+
+// checkHosts validates every entry of the hosts map and the sites they point
+// to.
+func checkHosts(conf *config.ServerConfig, doc *config.Document) config.Problems {
+	var problems config.Problems
+
+	file := conf.File
+	table := router.New()
+
+	for _, entry := range conf.Hosts {
+		line := entry.Line
+
+		r, err := table.Add(entry.Name, entry.Root)
+		if err != nil {
+			problems = append(problems, config.Problem{File: file, Line: line, Message: err.Error()})
+			continue
+		}
+
+		for _, alias := range entry.Aliases {
+			if _, err := table.Alias(alias, r); err != nil {
+				problems = append(problems, config.Problem{File: file, Line: doc.Line("hosts", entry.Name, "aliases"), Message: err.Error()})
+			}
+		}
+
+		info, err := os.Stat(entry.Root)
+		if err != nil {
+			problems = append(problems, config.Problem{File: file, Line: line, Message: fmt.Sprintf("Host %s: %s", entry.Name, err)})
+			continue
+		}
+
+		if !info.IsDir() {
+			problems = append(problems, config.Problem{File: file, Line: line, Message: fmt.Sprintf("Host %s does not point to a directory.", entry.Name)})
+			continue
+		}
+
+		problems = append(problems, host.Check(entry.Name, entry.Root)...)
+	}
+
+	if !doc.Has("hosts", router.Default) {
+		problems = append(problems, config.Problem{File: file, Line: doc.Line("hosts"), Message: "Default host was not provided.", Warning: true})
+	}
+
+	return problems
+}
+
+// Execute validates the settings file and all the sites it points to. It
+// returns an error if any problem was found, warnings are only printed.
+func (c *checkCommand) Execute() error {
+	file := config.FindFile(*flagSettings)
+
+	var problems config.Problems
+
+	doc, err := config.Open(file)
+	if err != nil {
+		problems = append(problems, config.ProblemFor(file, err))
+	} else {
+		conf, found := doc.ServerConfig()
+		problems = append(problems, found...)
+
+		if conf != nil {
+			problems = append(problems, checkHosts(conf, doc)...)
+		}
+	}
+
+	sort.Stable(problems)
+
+	for _, p := range problems {
+		fmt.Println(p.Error())
+	}
+
+	if n := problems.Errors(); n > 0 {
+		return fmt.Errorf("%d error(s) and %d warning(s) found.", n, len(problems)-n)
+	}
+
+	fmt.Printf("%s: OK, %d warning(s).\n", file, len(problems))
+
+	return nil
+}
+
+func init() {
+	// Describing the "check" subcommand.
+	cli.Register("check", cli.Entry{
+		Name:        "check",
+		Description: "Validates the settings file, sites and templates.",
+		Arguments:   []string{"c"},
+		Command:     &checkCommand{},
+	})
+}
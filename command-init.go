@@ -41,9 +41,16 @@ func (c *initCommand) Execute() (err error) {
 	// Default PATH if the current working directory.
 	dest := "."
 
+	// Arguments after the subcommand name.
+	f := flag.NewFlagSet("init", flag.ContinueOnError)
+
+	if err = f.Parse(flag.Args()[1:]); err != nil {
+		return err
+	}
+
 	// If a PATH was given, use it instead of the default PATH.
-	if flag.NArg() > 1 {
-		dest = flag.Arg(1)
+	if f.NArg() > 0 {
+		dest = f.Arg(0)
 	}
 
 	// Verifying PATH.
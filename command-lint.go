@@ -0,0 +1,127 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"menteslibres.net/gosexy/cli"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/host"
+)
+
+// Command line settings.
+var flagLintFormat = flag.String("format", "text", "Output format for luminos lint: \"text\" or \"json\".")
+
+// lintCommand is the structure that provides instructions for the "luminos
+// lint" subcommand.
+type lintCommand struct {
+}
+
+// lintReport is the JSON output of "luminos lint".
+type lintReport struct {
+	Host   string           `json:"host"`
+	Issues []host.LintIssue `json:"issues"`
+}
+
+// writeLintReports writes the issues of every host in the given format.
+func writeLintReports(w io.Writer, format string, reports []lintReport) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(reports)
+	}
+
+	for _, report := range reports {
+		for _, issue := range report.Issues {
+			if _, err := fmt.Fprintf(w, "%s: %s\n", report.Host, issue); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Execute lints every host and returns an error if any issue was found.
+func (c *lintCommand) Execute() error {
+	if *flagLintFormat != "text" && *flagLintFormat != "json" {
+		return fmt.Errorf("Unknown output format %q.", *flagLintFormat)
+	}
+
+	*flagSettings = config.FindFile(*flagSettings)
+
+	conf, problems := config.LoadServerConfig(*flagSettings)
+	if err := problems.Err(); err != nil {
+		return fmt.Errorf("Error while reading settings file %s: %q", *flagSettings, err)
+	}
+
+	entries := conf.Hosts
+
+	reports := make([]lintReport, 0, len(entries))
+	total := 0
+
+	for _, entry := range entries {
+		h, err := host.New(entry.Name, entry.Root)
+		if err != nil {
+			return fmt.Errorf("Failed to initialize host %s: %q", entry.Name, err)
+		}
+
+		issues, err := h.Lint()
+		h.Close()
+
+		if err != nil {
+			return fmt.Errorf("Failed to lint host %s: %q", entry.Name, err)
+		}
+
+		if issues == nil {
+			issues = []host.LintIssue{}
+		}
+
+		reports = append(reports, lintReport{Host: entry.Name, Issues: issues})
+		total += len(issues)
+	}
+
+	if err := writeLintReports(os.Stdout, *flagLintFormat, reports); err != nil {
+		return err
+	}
+
+	if total > 0 {
+		return fmt.Errorf("%d issue(s) found.", total)
+	}
+
+	return nil
+}
+
+func init() {
+	// Describing the "lint" subcommand.
+	cli.Register("lint", cli.Entry{
+		Name:        "lint",
+		Description: "Looks for broken links and structure problems in markdown pages.",
+		Arguments:   []string{"c", "format"},
+		Command:     &lintCommand{},
+	})
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"menteslibres.net/luminos/host"
+)
+
+func TestWriteLintReports(t *testing.T) {
+	reports := []lintReport{
+		{
+			Host: "example.org",
+			Issues: []host.LintIssue{
+				{File: "/site/content/index.md", Line: 3, URL: "/", Rule: host.LintBrokenLink, Message: "Link /missing points to a page that does not exist."},
+				{File: "/site/content/about.md", URL: "/about", Rule: host.LintImageAlt, Message: "Image /logo.png has no alt text."},
+			},
+		},
+		{
+			Host:   "example.com",
+			Issues: []host.LintIssue{},
+		},
+	}
+
+	var buf bytes.Buffer
+
+	if err := writeLintReports(&buf, "text", reports); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "example.org: /site/content/index.md:3: [broken-link] Link /missing points to a page that does not exist.\n" +
+		"example.org: /site/content/about.md: [image-alt] Image /logo.png has no alt text.\n"
+
+	if buf.String() != expected {
+		t.Fatalf("Expecting %q, got %q.", expected, buf.String())
+	}
+
+	buf.Reset()
+
+	if err := writeLintReports(&buf, "json", reports); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hosts without issues have an empty list, and issues without line omit
+	// it.
+	for _, s := range []string{`"issues": []`, `"line": 3`, `"rule": "image-alt"`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Fatalf("Expecting %s in %s.", s, buf.String())
+		}
+	}
+	if strings.Count(buf.String(), `"line"`) != 1 {
+		t.Fatalf("Expecting a single line entry in %s.", buf.String())
+	}
+
+	var decoded []lintReport
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded, reports) {
+		t.Fatalf("Expecting %v, got %v.", reports, decoded)
+	}
+}
+
+func TestLintFormat(t *testing.T) {
+	defer func(format string) { *flagLintFormat = format }(*flagLintFormat)
+
+	*flagLintFormat = "xml"
+
+	if err := (&lintCommand{}).Execute(); err == nil || !strings.Contains(err.Error(), "xml") {
+		t.Fatalf("Expecting an error for an unknown format, got %v.", err)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"menteslibres.net/gosexy/cli"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/router"
+)
+
+// routesCommand is the structure that provides instructions for the "luminos
+// routes" subcommand.
+type routesCommand struct {
+}
+
+// orAny returns "*" for empty values.
+func orAny(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
+
+// Execute prints the routing table of a settings file, sorted by precedence.
+func (c *routesCommand) Execute() error {
+	*flagSettings = config.FindFile(*flagSettings)
+
+	conf, problems := config.LoadServerConfig(*flagSettings)
+	if err := problems.Err(); err != nil {
+		return fmt.Errorf("Error while reading settings file %s: %q", *flagSettings, err)
+	}
+
+	entries := conf.Hosts
+
+	table, err := newRoutingTable(entries, func(entry config.HostConfig) interface{} {
+		return entry.Root
+	})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ROUTE\tHOST\tPORT\tPATH\tDIRECTORY\tALIAS OF")
+
+	for _, r := range table.Routes() {
+		if r.Pattern == router.Default {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Pattern, "*", "*", "*", r.Value, "-")
+			continue
+		}
+		path := r.Path
+		if path == "" {
+			path = "/"
+		}
+		alias := r.AliasOf
+		if alias == "" {
+			alias = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Pattern, orAny(r.Host), orAny(r.Port), path, r.Value, alias)
+	}
+
+	return w.Flush()
+}
+
+func init() {
+	// Describing the "routes" subcommand.
+	cli.Register("routes", cli.Entry{
+		Name:        "routes",
+		Description: "Prints the routing table of a settings file.",
+		Arguments:   []string{"c"},
+		Command:     &routesCommand{},
+	})
+}
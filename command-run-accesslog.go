@@ -0,0 +1,170 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"menteslibres.net/luminos/accesslog"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/host"
+	"menteslibres.net/luminos/logger"
+)
+
+// accessLog is where and how requests are logged.
+type accessLog struct {
+	file   *accesslog.File
+	format accesslog.Format
+}
+
+// Access log files that are in use, by name. Files are shared by all the
+// settings that name them, so they survive reloads.
+var accessLogFiles = map[string]*accesslog.File{}
+
+// accessLogs are the access logs of a hosts table that is being built.
+type accessLogs struct {
+	// Access log of the server.
+	server *accessLog
+	// Access logs of hosts that have their own file, by host name.
+	hosts map[string]*accessLog
+	// Files that were not in use yet, by name.
+	opened map[string]*accesslog.File
+}
+
+// open returns the access log for the given file name, the file is opened only
+// if it's not in use yet.
+func (a *accessLogs) open(name string, format accesslog.Format) (*accessLog, error) {
+	f, ok := accessLogFiles[name]
+	if !ok {
+		if f, ok = a.opened[name]; !ok {
+			var err error
+			if f, err = accesslog.Open(name); err != nil {
+				return nil, err
+			}
+			a.opened[name] = f
+		}
+	}
+	return &accessLog{file: f, format: format}, nil
+}
+
+// use shares the files that were opened, once the hosts table that needs them
+// has replaced the current one.
+func (a *accessLogs) use() {
+	for name, f := range a.opened {
+		accessLogFiles[name] = f
+	}
+	a.opened = nil
+}
+
+// discard closes the files that were opened, when the hosts table that needs
+// them is not going to be used.
+func (a *accessLogs) discard() {
+	for name, f := range a.opened {
+		if err := f.Close(); err != nil {
+			logger.Errorf("Could not close access log %s: %q", name, err)
+		}
+	}
+	a.opened = nil
+}
+
+// openAccessLogs opens the access log of the server and the access logs of
+// the hosts that have their own file. Files in use are not touched, new files
+// are only shared after calling use.
+func openAccessLogs(c *config.ServerConfig) (*accessLogs, error) {
+	format, err := accesslog.ParseFormat(c.Server.AccessLog.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &accessLogs{
+		hosts:  make(map[string]*accessLog),
+		opened: make(map[string]*accesslog.File),
+	}
+
+	if a.server, err = a.open(c.Server.AccessLog.File, format); err != nil {
+		a.discard()
+		return nil, err
+	}
+
+	for _, entry := range c.Hosts {
+		if entry.AccessLog == "" {
+			continue
+		}
+		if a.hosts[entry.Name], err = a.open(entry.AccessLog, format); err != nil {
+			a.discard()
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// closeUnusedAccessLogs closes the files that are not used by the given hosts
+// table.
+func closeUnusedAccessLogs(t *hostTable) {
+	used := make(map[*accesslog.File]bool)
+
+	if t.accessLog != nil {
+		used[t.accessLog.file] = true
+	}
+	for _, l := range t.hostLogs {
+		used[l.file] = true
+	}
+
+	for name, f := range accessLogFiles {
+		if used[f] {
+			continue
+		}
+		if err := f.Close(); err != nil {
+			logger.Errorf("Could not close access log %s: %q", name, err)
+		}
+		delete(accessLogFiles, name)
+	}
+}
+
+// reopenLogFiles reopens the application log file and all access log files,
+// so files that were moved away by a log rotation tool are created again.
+func reopenLogFiles() {
+	if appLogFile != nil {
+		if err := appLogFile.Reopen(); err != nil {
+			logger.Errorf("Could not reopen log file %s: %q", appLogFile.Name, err)
+		}
+	}
+	for name, f := range accessLogFiles {
+		if err := f.Reopen(); err != nil {
+			logger.Errorf("Could not reopen access log %s: %q", name, err)
+		}
+	}
+}
+
+// logRequest writes an entry to the access log of the given host, which is
+// nil for requests that did not match any host.
+func (t *hostTable) logRequest(h *host.Host, e *accesslog.Entry) {
+	l := t.hostLogs[h]
+	if l == nil {
+		l = t.accessLog
+	}
+	if l == nil {
+		return
+	}
+	if _, err := l.file.Write(e.Line(l.format)); err != nil {
+		logger.Errorf("Could not write to access log %s: %q", l.file.Name, err)
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"menteslibres.net/luminos/config"
+)
+
+// acmeHostPolicy allows certificates only for the host names and aliases that
+// appear on the hosts map. Wildcards are not allowed.
+func acmeHostPolicy(ctx context.Context, name string) error {
+	for _, r := range currentHostTable().routes.Routes() {
+		if r.Host != "" && !r.IsWildcard() && r.Host == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("Host %q is not in the hosts map.", name)
+}
+
+// acmeClient returns an ACME client for the given directory URL. If a CA file
+// is given, it's trusted when talking to the ACME server (useful for testing
+// servers, like pebble, that use self-signed certificates).
+func acmeClient(directory string, caFile string) (*acme.Client, error) {
+	client := &acme.Client{
+		DirectoryURL: directory,
+	}
+
+	if caFile == "" {
+		return client, nil
+	}
+
+	buf, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read CA file %s: %q", caFile, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(buf) {
+		return nil, fmt.Errorf("No certificates found in %s.", caFile)
+	}
+
+	client.HTTPClient = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: pool},
+		},
+	}
+
+	return client, nil
+}
+
+// newACMEManager reads the server.tls.acme section and returns a certificate
+// manager that obtains and renews certificates for every host on the hosts
+// map. Enabling ACME means accepting the terms of service of the CA.
+func newACMEManager(section *config.ACMEConfig) (*autocert.Manager, error) {
+	cache := section.Cache
+	if cache == "" {
+		cache = config.DefaultACMECache
+	}
+
+	directory := section.Directory
+	if directory == "" {
+		directory = autocert.DefaultACMEDirectory
+	}
+
+	client, err := acmeClient(directory, section.CA)
+	if err != nil {
+		return nil, err
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cache),
+		HostPolicy: acmeHostPolicy,
+		Email:      section.Email,
+		Client:     client,
+	}
+
+	return manager, nil
+}
@@ -0,0 +1,202 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/acme/autocert"
+	"menteslibres.net/luminos/config"
+)
+
+func TestACMEHostPolicy(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+
+	s.config.Hosts.Set("example.org", s.root)
+	s.config.Hosts.Set("example.com/docs", s.root)
+	s.config.Hosts.Set("*.example.net", s.root)
+
+	for i := range s.config.Hosts {
+		if s.config.Hosts[i].Name == "example.org" {
+			s.config.Hosts[i].Aliases = []string{"www.example.org"}
+		}
+	}
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	allowed := []string{"example.org", "www.example.org", "example.com"}
+
+	for _, name := range allowed {
+		if err := acmeHostPolicy(context.Background(), name); err != nil {
+			t.Fatalf("Expecting %q to be allowed, got %q.", name, err)
+		}
+	}
+
+	rejected := []string{"", "default", "other.org", "foo.example.net", "*.example.net", "sub.example.org"}
+
+	for _, name := range rejected {
+		if err := acmeHostPolicy(context.Background(), name); err == nil {
+			t.Fatalf("Expecting %q to be rejected.", name)
+		}
+	}
+}
+
+func TestNewACMEManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luminos-acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manager, err := newACMEManager(&config.ACMEConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manager.Cache != autocert.DirCache(config.DefaultACMECache) {
+		t.Fatalf("Expecting the default cache, got %v.", manager.Cache)
+	}
+	if manager.Client.DirectoryURL != autocert.DefaultACMEDirectory {
+		t.Fatalf("Expecting the default directory, got %q.", manager.Client.DirectoryURL)
+	}
+	if manager.Client.HTTPClient != nil {
+		t.Fatal("Expecting the default HTTP client.")
+	}
+
+	ca := writeTestCertificate(t, dir, "ca")
+
+	manager, err = newACMEManager(&config.ACMEConfig{
+		Email:     "admin@example.org",
+		Cache:     filepath.Join(dir, "certs"),
+		Directory: "https://localhost:14000/dir",
+		CA:        ca.Cert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manager.Cache != autocert.DirCache(filepath.Join(dir, "certs")) {
+		t.Fatalf("Expecting the given cache, got %v.", manager.Cache)
+	}
+	if manager.Client.DirectoryURL != "https://localhost:14000/dir" {
+		t.Fatalf("Expecting the given directory, got %q.", manager.Client.DirectoryURL)
+	}
+	if manager.Email != "admin@example.org" {
+		t.Fatalf("Expecting the given email, got %q.", manager.Email)
+	}
+	if manager.Client.HTTPClient == nil {
+		t.Fatal("Expecting an HTTP client that trusts the given CA.")
+	}
+
+	bad := []string{filepath.Join(dir, "missing.pem"), ca.Key}
+
+	for _, file := range bad {
+		if _, err := newACMEManager(&config.ACMEConfig{CA: file}); err == nil {
+			t.Fatalf("Expecting an error for CA file %s.", file)
+		}
+	}
+}
+
+// freePort returns a TCP port that nobody is listening on.
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func TestACMEChallenge(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+
+	s.config.Hosts.Set("example.org", s.root)
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	s.write(map[string]string{"certs/token+http-01": "key-authorization"})
+
+	port := freePort(t)
+
+	services, err := listen(config.ListenerConfig{
+		Type: "standalone",
+		Bind: "127.0.0.1",
+		Port: config.Port(port),
+		TLS: &config.TLSConfig{
+			Redirect: freePort(t),
+			ACME:     &config.ACMEConfig{Cache: s.path("certs")},
+		},
+	}, server{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, service := range services {
+		defer service.listener.Close()
+	}
+
+	if len(services) != 2 {
+		t.Fatalf("Expecting a redirect service, got %d services.", len(services))
+	}
+
+	tests := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"http://example.org/.well-known/acme-challenge/token", http.StatusOK, "key-authorization"},
+		{"http://example.org/.well-known/acme-challenge/missing", http.StatusNotFound, ""},
+		{"http://other.org/.well-known/acme-challenge/token", http.StatusForbidden, ""},
+		{"http://example.org/page", http.StatusMovedPermanently, ""},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		services[1].handler.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+
+		if w.Code != test.status {
+			t.Fatalf("Expecting %d for %s, got %d.", test.status, test.url, w.Code)
+		}
+		if test.body != "" && w.Body.String() != test.body {
+			t.Fatalf("Expecting %q for %s, got %q.", test.body, test.url, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	services[1].handler.ServeHTTP(w, httptest.NewRequest("GET", "http://example.org/page", nil))
+
+	if location := w.Header().Get("Location"); location != fmt.Sprintf("https://example.org:%d/page", port) {
+		t.Fatalf("Expecting a redirect to HTTPS, got %q.", location)
+	}
+}
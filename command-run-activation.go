@@ -0,0 +1,179 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"menteslibres.net/luminos/logger"
+)
+
+// First file descriptor passed by the service manager.
+const listenFdsStart = 3
+
+// inheritedListener is a listening socket that was passed to us by a service
+// manager, or by the process we are replacing.
+type inheritedListener struct {
+	net.Listener
+	// Name given to the socket by the service manager, FileDescriptorName= in
+	// systemd units.
+	name string
+	// True if the socket belongs to a service manager. Sockets passed by an
+	// upgrade are ours.
+	managed bool
+}
+
+// Inherited sockets that were not claimed by any listener yet.
+var inherited []*inheritedListener
+
+// loadInheritedListeners reads the sockets passed to us using the
+// LISTEN_FDS/LISTEN_FDNAMES protocol. LISTEN_PID, when given, must match our
+// own PID. The environment variables are unset so they are not passed to child
+// processes.
+func loadInheritedListeners() error {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+
+	upgraded := os.Getenv(envUpgradeVariable) != ""
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if fds == "" {
+		return nil
+	}
+
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// Those sockets were not meant for us.
+		return nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return fmt.Errorf("Invalid LISTEN_FDS value %q.", fds)
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(fdNames) {
+			name = fdNames[i]
+		}
+
+		f := os.NewFile(uintptr(listenFdsStart+i), name)
+
+		// FileListener duplicates the descriptor, so we can close ours.
+		l, err := net.FileListener(f)
+		f.Close()
+
+		if err != nil {
+			return fmt.Errorf("Inherited file descriptor %d (%s) is not a listening socket: %q", listenFdsStart+i, name, err)
+		}
+
+		logger.Infof("Inherited socket %s (%s).", name, l.Addr())
+
+		inherited = append(inherited, &inheritedListener{Listener: l, name: name, managed: !upgraded})
+	}
+
+	return nil
+}
+
+// sameAddress returns true if the listener address addr is the same as the
+// given network address.
+func sameAddress(addr net.Addr, domain string, address string) bool {
+	if addr.Network() != domain {
+		return false
+	}
+
+	if domain == envServerDomain {
+		return addr.String() == address
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+
+	lhost, lport, err := net.SplitHostPort(addr.String())
+	if err != nil || port != lport {
+		return false
+	}
+
+	ip, lip := net.ParseIP(host), net.ParseIP(lhost)
+
+	if host == "" || (ip != nil && ip.IsUnspecified()) {
+		return lip == nil || lip.IsUnspecified()
+	}
+
+	if ip == nil {
+		return host == lhost
+	}
+
+	return ip.Equal(lip)
+}
+
+// takeListener claims an inherited socket, by name if one was given or by
+// address otherwise. It returns nil if no socket matched.
+func takeListener(name string, domain string, address string) *inheritedListener {
+	for i, l := range inherited {
+		if (name != "" && l.name == name) || (name == "" && sameAddress(l.Addr(), domain, address)) {
+			inherited = append(inherited[:i], inherited[i+1:]...)
+			return l
+		}
+	}
+	return nil
+}
+
+// listenOrInherit returns an inherited socket that matches the given name or
+// address, if any. Otherwise it creates a new network listener. The returned
+// boolean is true for sockets that belong to a service manager, their files
+// must not be removed.
+func listenOrInherit(name string, domain string, address string) (net.Listener, bool, error) {
+	if l := takeListener(name, domain, address); l != nil {
+		return l.Listener, l.managed, nil
+	}
+
+	if name != "" && len(inherited) > 0 {
+		logger.Warnf("No inherited socket named %q, listening at %s.", name, address)
+	}
+
+	l, err := net.Listen(domain, address)
+	return l, false, err
+}
+
+// closeUnclaimedListeners closes inherited sockets that do not match any
+// listener.
+func closeUnclaimedListeners() {
+	for _, l := range inherited {
+		logger.Warnf("Inherited socket %s (%s) does not match any listener.", l.name, l.Addr())
+		l.Close()
+	}
+	inherited = nil
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// Environment variable that makes TestActivationChild check the sockets it
+// inherited: "fds" or "pid".
+const envTestActivation = "LUMINOS_TEST_ACTIVATION"
+
+func TestSameAddress(t *testing.T) {
+	tests := []struct {
+		addr    net.Addr
+		domain  string
+		address string
+		same    bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}, "tcp", "127.0.0.1:80", true},
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}, "tcp", "127.0.0.1:81", false},
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}, "tcp", "10.0.0.1:80", false},
+		{&net.TCPAddr{IP: net.IPv6unspecified, Port: 80}, "tcp", ":80", true},
+		{&net.TCPAddr{IP: net.IPv4zero, Port: 80}, "tcp", "0.0.0.0:80", true},
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 80}, "tcp", ":80", false},
+		{&net.TCPAddr{IP: net.ParseIP("::1"), Port: 80}, "tcp", "[::1]:80", true},
+		{&net.UnixAddr{Name: "/run/luminos.sock", Net: "unix"}, "unix", "/run/luminos.sock", true},
+		{&net.UnixAddr{Name: "/run/luminos.sock", Net: "unix"}, "unix", "/run/other.sock", false},
+		{&net.UnixAddr{Name: "/run/luminos.sock", Net: "unix"}, "tcp", "/run/luminos.sock", false},
+	}
+
+	for _, test := range tests {
+		if same := sameAddress(test.addr, test.domain, test.address); same != test.same {
+			t.Errorf("%s %s %s: expecting %v.", test.addr, test.domain, test.address, test.same)
+		}
+	}
+}
+
+func TestTakeListener(t *testing.T) {
+	var listeners []net.Listener
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		listeners = append(listeners, l)
+	}
+
+	inherited = []*inheritedListener{
+		{Listener: listeners[0], name: "web", managed: true},
+		{Listener: listeners[1], name: "admin", managed: true},
+		{Listener: listeners[2], name: "unknown", managed: true},
+	}
+	defer func() {
+		inherited = nil
+	}()
+
+	if l := takeListener("admin", "tcp", "127.0.0.1:1"); l == nil || l.Listener != listeners[1] {
+		t.Fatal("Expecting a match by name.")
+	}
+
+	if l := takeListener("", "tcp", listeners[0].Addr().String()); l == nil || l.Listener != listeners[0] {
+		t.Fatal("Expecting a match by address.")
+	}
+
+	if l := takeListener("missing", "tcp", listeners[2].Addr().String()); l != nil {
+		t.Fatal("Names must take precedence over addresses.")
+	}
+
+	l, managed, err := listenOrInherit("", "tcp", listeners[2].Addr().String())
+	if err != nil || l != listeners[2] || !managed {
+		t.Fatalf("Expecting the inherited socket, got %v %v %v.", l, managed, err)
+	}
+
+	if len(inherited) != 0 {
+		t.Fatalf("Expecting all sockets to be claimed, %d left.", len(inherited))
+	}
+}
+
+// TestActivationChild is started by TestInheritedListeners with sockets on
+// file descriptors 3, 4 and 5.
+func TestActivationChild(t *testing.T) {
+	mode := os.Getenv(envTestActivation)
+	if mode == "" {
+		return
+	}
+
+	addrs := strings.Split(os.Getenv("LUMINOS_TEST_ADDRS"), ",")
+
+	if err := loadInheritedListeners(); err != nil {
+		t.Fatal(err)
+	}
+
+	if os.Getenv("LISTEN_FDS") != "" || os.Getenv("LISTEN_PID") != "" || os.Getenv("LISTEN_FDNAMES") != "" {
+		t.Fatal("Expecting the environment to be cleared.")
+	}
+
+	if mode == "pid" {
+		if len(inherited) != 0 {
+			t.Fatalf("Sockets for another process were used: %d", len(inherited))
+		}
+		return
+	}
+
+	if len(inherited) != 3 || inherited[2].name != "unknown" {
+		t.Fatalf("Unexpected sockets: %v", inherited)
+	}
+
+	if l := takeListener("admin", "", ""); l == nil || l.Addr().String() != addrs[1] || !l.managed {
+		t.Fatalf("Expecting admin at %s, got %v.", addrs[1], l)
+	}
+
+	if l := takeListener("", "tcp", addrs[0]); l == nil || l.name != "web" {
+		t.Fatalf("Expecting web at %s, got %v.", addrs[0], l)
+	}
+
+	// The last socket is not claimed by any listener.
+	left := inherited[0]
+	closeUnclaimedListeners()
+
+	if len(inherited) != 0 {
+		t.Fatal("Expecting unclaimed sockets to be dropped.")
+	}
+
+	if _, err := left.Accept(); err == nil {
+		t.Fatal("Expecting unclaimed sockets to be closed.")
+	}
+}
+
+func TestInheritedListeners(t *testing.T) {
+	var files []*os.File
+	var addrs []string
+
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+
+		files = append(files, f)
+		addrs = append(addrs, l.Addr().String())
+	}
+
+	run := func(mode string, env ...string) {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestActivationChild$")
+		cmd.ExtraFiles = files
+		cmd.Env = append(os.Environ(),
+			envTestActivation+"="+mode,
+			"LUMINOS_TEST_ADDRS="+strings.Join(addrs, ","),
+			"LISTEN_FDS=3",
+			"LISTEN_FDNAMES=web:admin",
+		)
+		cmd.Env = append(cmd.Env, env...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v\n%s", mode, err, out)
+		}
+	}
+
+	run("fds")
+	run("pid", "LISTEN_PID=1")
+}
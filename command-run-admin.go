@@ -0,0 +1,125 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/logger"
+	"menteslibres.net/luminos/metrics"
+)
+
+var (
+	// Requests served, by host, method and status.
+	requestsTotal = metrics.NewCounter(
+		"luminos_http_requests_total",
+		"Requests served, by host, method and status.",
+		"host", "method", "status",
+	)
+
+	// Settings reloads, by result ("success" or "failure").
+	reloadsTotal = metrics.NewCounter(
+		"luminos_config_reloads_total",
+		"Settings reloads, by result.",
+		"result",
+	)
+
+	_ = metrics.NewGaugeFunc(
+		"luminos_hosts",
+		"Hosts that are currently being served.",
+		func() float64 { return float64(len(currentHostTable().hosts)) },
+	)
+
+	_ = metrics.NewGaugeFunc(
+		"luminos_render_cache_entries",
+		"Rendered pages that are kept in memory.",
+		func() float64 {
+			if pages := currentHostTable().pages; pages != nil {
+				return float64(pages.Len())
+			}
+			return 0
+		},
+	)
+)
+
+// Methods that are used as label values, others are counted as "OTHER".
+var knownMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"POST":    true,
+	"PUT":     true,
+	"DELETE":  true,
+	"PATCH":   true,
+	"OPTIONS": true,
+}
+
+// countRequest adds a request to the requests counter.
+func countRequest(name string, req *http.Request, status int) {
+	method := req.Method
+	if !knownMethods[method] {
+		method = "OTHER"
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	requestsTotal.Inc(name, method, strconv.Itoa(status))
+}
+
+// adminHandler returns the handler of the admin listener.
+func adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if !serveHealth(w, req) {
+			http.NotFound(w, req)
+		}
+	})
+	return mux
+}
+
+// listenAdmin creates the admin listener, a plain HTTP server that exposes
+// metrics at /metrics and the health endpoints, if enabled.
+func listenAdmin(entry *config.AdminConfig) (*service, error) {
+	domain := envServerDomain
+	address := entry.Socket
+
+	if address == "" {
+		domain = envServerProtocol
+		address = fmt.Sprintf("%s:%d", entry.Bind, entry.Port)
+	}
+
+	listener, managed, err := listenOrInherit(entry.Name, domain, address)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create admin listener: %q", err)
+	}
+
+	s := newService("standalone", listener, adminHandler(), nil)
+	s.name = entry.Name
+	s.keepSocket = managed
+
+	logger.Infof("Metrics are available at %s/metrics.", listener.Addr())
+
+	return s, nil
+}
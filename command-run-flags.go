@@ -0,0 +1,247 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/logger"
+)
+
+// Environment variables.
+const (
+	envSettingsVariable = "LUMINOS_SETTINGS"
+	envHostsVariable    = "LUMINOS_HOSTS"
+)
+
+// Environment variables that override server settings.
+var envServerVariables = map[string]string{
+	"LUMINOS_SERVER_BIND":             "bind",
+	"LUMINOS_SERVER_PORT":             "port",
+	"LUMINOS_SERVER_SOCKET":           "socket",
+	"LUMINOS_SERVER_TYPE":             "type",
+	"LUMINOS_SERVER_PID_FILE":         "pid_file",
+	"LUMINOS_SERVER_SHUTDOWN_TIMEOUT": "shutdown_timeout",
+}
+
+// Server settings that describe the listener.
+var listenerFlags = map[string]bool{
+	"bind":   true,
+	"port":   true,
+	"socket": true,
+	"type":   true,
+}
+
+// settingOverrides are settings given by environment variables and command
+// line flags.
+type settingOverrides struct {
+	// Values of the server section, by key.
+	server map[string]string
+	// NAME=PATH pairs.
+	hosts []string
+}
+
+// Overrides given by environment variables and command line flags, they are
+// applied every time settings are loaded.
+var overrides settingOverrides
+
+// hostFlag collects NAME=PATH pairs.
+type hostFlag []string
+
+func (h *hostFlag) String() string {
+	return strings.Join(*h, ",")
+}
+
+func (h *hostFlag) Set(value string) error {
+	if _, _, err := splitHost(value); err != nil {
+		return err
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// splitHost splits a NAME=PATH pair.
+func splitHost(value string) (string, string, error) {
+	i := strings.Index(value, "=")
+	if i < 1 || i == len(value)-1 {
+		return "", "", fmt.Errorf("Expecting NAME=PATH, got %q.", value)
+	}
+	return value[:i], value[i+1:], nil
+}
+
+// runFlags holds the flags of the "run" and "serve" subcommands.
+type runFlags struct {
+	*flag.FlagSet
+	bind       string
+	port       int
+	socket     string
+	serverType string
+	hosts      hostFlag
+}
+
+// newRunFlags defines the flags for the given subcommand. Global -c, -dev and
+// -log-level flags are still accepted before the subcommand name.
+func newRunFlags(name string) *runFlags {
+	f := &runFlags{
+		FlagSet: flag.NewFlagSet(name, flag.ContinueOnError),
+	}
+
+	f.StringVar(flagSettings, "c", *flagSettings, "Path to the settings.yaml file.")
+	f.BoolVar(flagDev, "dev", *flagDev, "Development mode: reload pages in the browser when files change.")
+	f.StringVar(flagLogLevel, "log-level", *flagLogLevel, "Minimum level of log messages: debug, info, warn or error.")
+	f.StringVar(&f.bind, "bind", "", "The IPv4 or IPv6 address to bind to.")
+	f.IntVar(&f.port, "port", 0, "Port to listen on.")
+	f.StringVar(&f.socket, "socket", "", "Unix socket to listen on, instead of an address and a port.")
+	f.StringVar(&f.serverType, "type", "", "Server type: \"standalone\" or \"fastcgi\".")
+	f.Var(&f.hosts, "host", "Serves PATH as the NAME host, may be given more than once.")
+
+	return f
+}
+
+// parse reads the subcommand flags from args and the environment, and sets the
+// overrides for settings files. Flags take precedence over environment
+// variables.
+func (f *runFlags) parse(args []string) error {
+	if err := f.Parse(args); err != nil {
+		return err
+	}
+
+	// The log level is needed before settings are read.
+	if *flagLogLevel != "" {
+		level, err := logger.ParseLevel(*flagLogLevel)
+		if err != nil {
+			return err
+		}
+		logger.Default().SetLevel(level)
+	}
+
+	values := make(map[string]string)
+
+	names := make([]string, 0, len(envServerVariables))
+	for name := range envServerVariables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			values[envServerVariables[name]] = value
+		}
+	}
+
+	var hostList []string
+	if value := os.Getenv(envHostsVariable); value != "" {
+		hostList = strings.Split(value, ",")
+	}
+
+	settingsGiven := false
+
+	visit := func(fl *flag.Flag) {
+		switch fl.Name {
+		case "bind", "port", "socket", "type":
+			values[fl.Name] = fl.Value.String()
+		case "c":
+			settingsGiven = true
+		}
+	}
+	flag.Visit(visit)
+	f.Visit(visit)
+
+	hostList = append(hostList, f.hosts...)
+
+	if value := os.Getenv(envSettingsVariable); value != "" && !settingsGiven {
+		*flagSettings = value
+	}
+
+	for key, value := range values {
+		switch key {
+		case "port":
+			if port, err := strconv.Atoi(value); err != nil || port < 0 || port > 65535 {
+				return fmt.Errorf("Invalid port %q.", value)
+			}
+		case "shutdown_timeout":
+			if _, err := config.ParseDuration(value); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, value := range hostList {
+		if _, _, err := splitHost(strings.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+
+	overrides = settingOverrides{server: values, hosts: hostList}
+
+	return nil
+}
+
+// hasHostOverrides returns true if hosts were given by flags or environment.
+func hasHostOverrides() bool {
+	return len(overrides.hosts) > 0
+}
+
+// applyOverrides sets the values given by flags and environment variables.
+func applyOverrides(c *config.ServerConfig) {
+	values := overrides.server
+	listener := &c.Server.Listener
+
+	for key, value := range values {
+		switch key {
+		case "bind":
+			listener.Bind = value
+		case "port":
+			port, _ := strconv.Atoi(value)
+			listener.Port = config.Port(port)
+		case "socket":
+			listener.Socket = value
+		case "type":
+			listener.Type = value
+		case "pid_file":
+			c.Server.PidFile = value
+		case "shutdown_timeout":
+			c.Server.ShutdownTimeout, _ = config.ParseDuration(value)
+		}
+		// A listener given by flags replaces the listeners of the settings
+		// file.
+		if listenerFlags[key] {
+			c.Server.Listeners = nil
+		}
+	}
+
+	// An address given without a socket means we're not using the socket.
+	if values["socket"] == "" && (values["bind"] != "" || values["port"] != "") {
+		listener.Socket = ""
+	}
+
+	for _, value := range overrides.hosts {
+		name, path, _ := splitHost(strings.TrimSpace(value))
+		c.Hosts.Set(name, path)
+	}
+}
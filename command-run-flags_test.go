@@ -0,0 +1,215 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"menteslibres.net/luminos/config"
+)
+
+// setEnv sets environment variables and returns a function that restores
+// their previous values.
+func setEnv(values map[string]string) func() {
+	previous := make(map[string]*string)
+	for name, value := range values {
+		if v, ok := os.LookupEnv(name); ok {
+			previous[name] = &v
+		} else {
+			previous[name] = nil
+		}
+		os.Setenv(name, value)
+	}
+	return func() {
+		for name, v := range previous {
+			if v == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *v)
+			}
+		}
+	}
+}
+
+// loadWithOverrides parses args with the given environment, then reads the
+// settings file and applies the overrides to it.
+func loadWithOverrides(t *testing.T, file string, env map[string]string, args ...string) (*config.ServerConfig, error) {
+	defer setEnv(env)()
+	defer func(settings, level string) {
+		*flagSettings, *flagLogLevel = settings, level
+		overrides = settingOverrides{}
+	}(*flagSettings, *flagLogLevel)
+
+	if err := newRunFlags("run").parse(args); err != nil {
+		return nil, err
+	}
+
+	c, problems := config.LoadServerConfig(file)
+	if err := problems.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	applyOverrides(c)
+
+	return c, nil
+}
+
+func TestOverrides(t *testing.T) {
+	root, err := ioutil.TempDir("", "luminos-flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	single := filepath.Join(root, "single.yaml")
+	multiple := filepath.Join(root, "multiple.yaml")
+
+	writeFiles(t, root, map[string]string{
+		"single.yaml": "server:\n" +
+			"  bind: \"127.0.0.1\"\n" +
+			"  port: 8080\n" +
+			"  pid_file: \"file.pid\"\n" +
+			"  shutdown_timeout: \"10s\"\n" +
+			"hosts:\n" +
+			"  default: \"" + root + "\"\n",
+		"multiple.yaml": "server:\n" +
+			"  listeners:\n" +
+			"    - type: \"fastcgi\"\n" +
+			"      socket: \"" + filepath.Join(root, "fcgi.sock") + "\"\n" +
+			"    - type: \"standalone\"\n" +
+			"      bind: \"127.0.0.1\"\n" +
+			"      port: 8080\n" +
+			"hosts:\n" +
+			"  default: \"" + root + "\"\n",
+	})
+
+	// Nothing given.
+	c, err := loadWithOverrides(t, single, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := c.Server.Listener; l.Bind != "127.0.0.1" || l.Port != 8080 || c.Server.PidFile != "file.pid" {
+		t.Fatalf("Expecting the settings file values, got %+v.", c.Server)
+	}
+
+	// Flags over environment over settings file.
+	c, err = loadWithOverrides(t, single, map[string]string{
+		"LUMINOS_SERVER_BIND":     "::1",
+		"LUMINOS_SERVER_PORT":     "8081",
+		"LUMINOS_SERVER_PID_FILE": "env.pid",
+		"LUMINOS_HOSTS":           "a.example.org=" + root,
+	}, "-port", "8082", "-host", "b.example.org="+root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if l := c.Server.Listener; l.Bind != "::1" || l.Port != 8082 {
+		t.Fatalf("Expecting bind from environment and port from flags, got %+v.", l)
+	}
+	if c.Server.PidFile != "env.pid" {
+		t.Fatalf("Expecting PID file from environment, got %q.", c.Server.PidFile)
+	}
+	if time.Duration(c.Server.ShutdownTimeout) != 10*time.Second {
+		t.Fatalf("Expecting shutdown timeout from the settings file, got %v.", c.Server.ShutdownTimeout)
+	}
+
+	names := []string{}
+	for _, h := range c.Hosts {
+		names = append(names, h.Name)
+	}
+	if len(names) != 3 {
+		t.Fatalf("Expecting hosts from file, environment and flags, got %v.", names)
+	}
+
+	// Settings other than the listener keep the listeners list.
+	c, err = loadWithOverrides(t, multiple, map[string]string{"LUMINOS_SERVER_PID_FILE": "env.pid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Server.Listeners) != 2 {
+		t.Fatalf("Expecting the listeners of the settings file, got %+v.", c.Server.Listeners)
+	}
+
+	// A listener given by flags replaces them.
+	c, err = loadWithOverrides(t, multiple, nil, "-bind", "127.0.0.2", "-port", "9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listeners := c.ListenerList()
+	if len(listeners) != 1 || listeners[0].Bind != "127.0.0.2" || listeners[0].Port != 9000 || listeners[0].Socket != "" {
+		t.Fatalf("Expecting a single listener from flags, got %+v.", listeners)
+	}
+
+	// A socket replaces the address of the settings file, and an address
+	// replaces the socket.
+	socket := filepath.Join(root, "luminos.sock")
+
+	c, err = loadWithOverrides(t, single, map[string]string{"LUMINOS_SERVER_SOCKET": socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Listener.Socket != socket {
+		t.Fatalf("Expecting socket from environment, got %+v.", c.Server.Listener)
+	}
+
+	writeFiles(t, root, map[string]string{
+		"socket.yaml": "server:\n" +
+			"  socket: \"" + socket + "\"\n" +
+			"hosts:\n" +
+			"  default: \"" + root + "\"\n",
+	})
+
+	c, err = loadWithOverrides(t, filepath.Join(root, "socket.yaml"), nil, "-port", "9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := c.Server.Listener; l.Socket != "" || l.Port != 9000 {
+		t.Fatalf("Expecting the port to replace the socket, got %+v.", l)
+	}
+}
+
+func TestInvalidOverrides(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		args []string
+	}{
+		{nil, []string{"-port", "http"}},
+		{nil, []string{"-unknown"}},
+		{nil, []string{"-host", "example.org"}},
+		{nil, []string{"-host", "=/var/www"}},
+		{nil, []string{"-log-level", "loud"}},
+		{map[string]string{"LUMINOS_SERVER_PORT": "70000"}, nil},
+		{map[string]string{"LUMINOS_SERVER_PORT": "-1"}, nil},
+		{map[string]string{"LUMINOS_SERVER_SHUTDOWN_TIMEOUT": "soon"}, nil},
+		{map[string]string{"LUMINOS_HOSTS": "example.org=/var/www,broken"}, nil},
+	}
+
+	for _, test := range tests {
+		if _, err := loadWithOverrides(t, "", test.env, test.args...); err == nil {
+			t.Fatalf("Expecting an error for %v and %v.", test.env, test.args)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// hostStatus is the readiness of a host.
+type hostStatus struct {
+	Ready  bool     `json:"ready"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// healthStatus is the body of the health and readiness responses.
+type healthStatus struct {
+	Status string                `json:"status"`
+	Hosts  map[string]hostStatus `json:"hosts,omitempty"`
+}
+
+// serveHealth answers requests for the health and readiness paths, if they
+// are enabled. It returns false for any other request, which is then routed to
+// the hosts.
+func serveHealth(w http.ResponseWriter, req *http.Request) bool {
+	t := currentHostTable()
+
+	if t.health == nil {
+		return false
+	}
+
+	switch req.URL.Path {
+	case t.health.Live:
+		writeHealth(w, http.StatusOK, healthStatus{Status: "ok"})
+	case t.health.Ready:
+		code, status := readiness(t)
+		writeHealth(w, code, status)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// readiness checks every host of a table, the table is ready only if all of
+// its hosts are.
+func readiness(t *hostTable) (int, healthStatus) {
+	status := healthStatus{
+		Status: "ready",
+		Hosts:  make(map[string]hostStatus),
+	}
+
+	code := http.StatusOK
+
+	for name, h := range t.hosts {
+		problems := h.Ready()
+		status.Hosts[name] = hostStatus{Ready: len(problems) == 0, Errors: problems}
+		if len(problems) > 0 {
+			status.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	return code, status
+}
+
+// writeHealth writes a health response as JSON.
+func writeHealth(w http.ResponseWriter, code int, status healthStatus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
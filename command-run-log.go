@@ -0,0 +1,105 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"menteslibres.net/luminos/accesslog"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/logger"
+)
+
+// Application log file, nil while messages are written to the standard error.
+var appLogFile *accesslog.File
+
+// logSettings are the values of the "log" section, ready to be applied to the
+// default logger.
+type logSettings struct {
+	level  logger.Level
+	format logger.Format
+	// Log file, empty for the standard error.
+	file string
+	// File that was opened for these settings, nil if the current one is kept.
+	opened *accesslog.File
+}
+
+// newLogSettings reads the "log" section of the settings. The -log-level flag
+// takes precedence over the settings file. Nothing changes until apply is
+// called.
+func newLogSettings(c *config.ServerConfig) (*logSettings, error) {
+	name := c.Server.Log.Level
+	if *flagLogLevel != "" {
+		name = *flagLogLevel
+	}
+
+	level, err := logger.ParseLevel(name)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := logger.ParseFormat(c.Server.Log.Format)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &logSettings{level: level, format: format, file: c.Server.Log.File}
+
+	if s.file != "" && (appLogFile == nil || appLogFile.Name != s.file) {
+		if s.opened, err = accesslog.Open(s.file); err != nil {
+			return nil, fmt.Errorf("Could not open log file: %q", err)
+		}
+	}
+
+	return s, nil
+}
+
+// apply configures the default logger.
+func (s *logSettings) apply() {
+	std := logger.Default()
+
+	switch {
+	case s.file == "" && appLogFile != nil:
+		std.SetOutput(os.Stderr)
+		appLogFile.Close()
+		appLogFile = nil
+	case s.opened != nil:
+		std.SetOutput(s.opened)
+		if appLogFile != nil {
+			appLogFile.Close()
+		}
+		appLogFile = s.opened
+	}
+
+	std.SetLevel(s.level)
+	std.SetFormat(s.format)
+}
+
+// discard closes the log file that was opened, when the settings are not
+// going to be applied.
+func (s *logSettings) discard() {
+	if s.opened != nil {
+		s.opened.Close()
+		s.opened = nil
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/logger"
+)
+
+// Signal that reloads settings and sites.
+var reloadSignal os.Signal = syscall.SIGHUP
+
+// reload reads the settings file and the settings of every site again. The
+// new settings are used only if they are valid and all hosts can be
+// initialized, otherwise the current settings and hosts are kept. Listeners
+// are not changed, that requires a restart, but the certificate files they
+// were given are read again.
+func reload() {
+	var c *config.ServerConfig
+	var err error
+
+	if settings.File != "" {
+		logger.Infof("Reloading settings file %s.", settings.File)
+		c, err = loadSettings(settings.File)
+	} else {
+		// Settings were not read from a file, only sites are reloaded.
+		c, err = settings, loadHosts(settings)
+	}
+
+	if err != nil {
+		reloadsTotal.Inc("failure")
+		logger.Errorf("Could not reload, keeping the current settings: %v", err)
+		return
+	}
+
+	reloadsTotal.Inc("success")
+
+	settings = c
+
+	if err := reloadCertificates(); err != nil {
+		logger.Errorf("Could not reload certificates, keeping the current ones: %v", err)
+	}
+
+	logger.Infof("Reloaded %d host(s).", len(c.Hosts))
+
+	if liveReload != nil {
+		liveReload.Notify(c.File)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/fcgi"
+	"os"
+
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/logger"
+)
+
+// service is a server attached to a network listener.
+type service struct {
+	// Server type, "fastcgi" or "standalone".
+	Type string
+	// Network listener.
+	listener net.Listener
+	// Handler for incoming requests.
+	handler http.Handler
+	// HTTP server, nil for FastCGI services.
+	http *http.Server
+	// Name of the listener, used to match inherited sockets.
+	name string
+	// True if the socket file belongs to someone else: the service manager
+	// that passed it to us or the process we passed it to.
+	keepSocket bool
+}
+
+// newService creates a service of the given type that is going to accept
+// connections from the given listener. If config is not nil the service
+// speaks HTTPS.
+func newService(serverType string, listener net.Listener, handler http.Handler, config *tls.Config) *service {
+	s := &service{
+		Type:     serverType,
+		listener: listener,
+		handler:  handler,
+	}
+
+	if serverType != "fastcgi" {
+		s.http = &http.Server{
+			Handler:   handler,
+			TLSConfig: config,
+		}
+	}
+
+	return s
+}
+
+// String returns a human readable description of the service.
+func (s *service) String() string {
+	switch {
+	case s.http == nil:
+		return "FastCGI server at " + s.listener.Addr().String()
+	case s.http.TLSConfig != nil:
+		return "HTTPS server at " + s.listener.Addr().String()
+	}
+	return "HTTP server at " + s.listener.Addr().String()
+}
+
+// serve accepts connections until the service is closed.
+func (s *service) serve() error {
+	var err error
+
+	switch {
+	case s.http == nil:
+		err = fcgi.Serve(s.listener, s.handler)
+	case s.http.TLSConfig != nil:
+		err = s.http.ServeTLS(s.listener, "", "")
+	default:
+		err = s.http.Serve(s.listener)
+	}
+
+	if err == http.ErrServerClosed {
+		return nil
+	}
+
+	return err
+}
+
+// close stops accepting new connections. HTTP services also wait for their
+// active connections to become idle.
+func (s *service) close(ctx context.Context) error {
+	if s.http != nil {
+		s.http.SetKeepAlivesEnabled(false)
+		return s.http.Shutdown(ctx)
+	}
+	return s.listener.Close()
+}
+
+// cleanup removes the unix socket file the service was listening on, if any.
+func (s *service) cleanup() {
+	addr := s.listener.Addr()
+	if addr.Network() != envServerDomain || s.keepSocket {
+		return
+	}
+	if err := os.Remove(addr.String()); err != nil && !os.IsNotExist(err) {
+		logger.Errorf("Could not remove socket %s: %q", addr, err)
+	}
+}
+
+// listen creates the network listener described by a listener entry and
+// returns its services: the server itself and, for HTTPS servers, an optional
+// plain HTTP server that redirects clients to HTTPS.
+func listen(entry config.ListenerConfig, handler http.Handler) ([]*service, error) {
+	serverType := entry.Type
+
+	if serverType != "fastcgi" && serverType != "standalone" {
+		return nil, fmt.Errorf("Unknown server type: %s", serverType)
+	}
+
+	domain := envServerDomain
+	address := entry.Socket
+
+	if address == "" {
+		domain = envServerProtocol
+		address = fmt.Sprintf("%s:%d", entry.Bind, entry.Port)
+	}
+
+	// Reading TLS settings, if any.
+	tlsConf, manager, err := tlsConfig(entry.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("Could not configure TLS: %q", err)
+	}
+
+	if tlsConf != nil && serverType != "standalone" {
+		return nil, errors.New("TLS is only available for the standalone server.")
+	}
+
+	listener, managed, err := listenOrInherit(entry.Name, domain, address)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create network listener: %q", err)
+	}
+
+	services := []*service{
+		newService(serverType, listener, handler, tlsConf),
+	}
+
+	services[0].name = entry.Name
+	services[0].keepSocket = managed
+
+	if tlsConf == nil {
+		return services, nil
+	}
+
+	// Optional plain HTTP listener that sends clients to HTTPS.
+	port := entry.TLS.Redirect
+
+	if port <= 0 {
+		if manager != nil {
+			logger.Warnf("No redirect listener, HTTP-01 challenges will not be answered.")
+		}
+		return services, nil
+	}
+
+	redirect, managed, err := listenOrInherit("", envServerProtocol, fmt.Sprintf("%s:%d", entry.Bind, port))
+	if err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("Could not create redirect listener: %q", err)
+	}
+
+	redirectTo := redirectHandler(int(entry.Port))
+
+	// HTTP-01 challenges are answered on the redirect listener.
+	if manager != nil {
+		redirectTo = manager.HTTPHandler(redirectTo)
+	}
+
+	services = append(services, newService("standalone", redirect, redirectTo, nil))
+	services[1].keepSocket = managed
+
+	return services, nil
+}
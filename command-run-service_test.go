@@ -0,0 +1,128 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"menteslibres.net/luminos/config"
+)
+
+// getFrom sends a GET request for the given host name to a network address.
+func getFrom(t *testing.T, network string, address string, name string) string {
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, address)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequest("GET", "http://"+name+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(body)
+}
+
+func TestListenersShareHosts(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"index.md":         "# Default\n",
+		"example/index.md": "# Example\n",
+	})
+	defer s.Close()
+
+	handler := newDrainHandler(&server{})
+
+	entries := []config.ListenerConfig{
+		{Type: "standalone", Bind: "127.0.0.1", Port: config.Port(freePort(t))},
+		{Type: "standalone", Socket: s.path("luminos.sock")},
+	}
+
+	var services []*service
+
+	for _, entry := range entries {
+		started, err := listen(entry, handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		services = append(services, started...)
+	}
+
+	defer func() {
+		for _, service := range services {
+			service.close(context.Background())
+			service.cleanup()
+		}
+		if _, err := os.Stat(s.path("luminos.sock")); !os.IsNotExist(err) {
+			t.Errorf("Expecting the socket to be removed, got %v.", err)
+		}
+	}()
+
+	for _, service := range services {
+		go service.serve()
+	}
+
+	expect := func(name string, text string) {
+		for _, service := range services {
+			addr := service.listener.Addr()
+			if body := getFrom(t, addr.Network(), addr.String(), name); !strings.Contains(body, text) {
+				t.Fatalf("Expecting %q from %s for %s, got %q.", text, addr, name, body)
+			}
+		}
+	}
+
+	expect("example.org", "Default")
+
+	// A reload changes the hosts of every listener.
+	s.config.Hosts.Set("example.org", s.path("example"))
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	expect("example.org", "Example")
+	expect("other.org", "Default")
+}
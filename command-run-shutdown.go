@@ -0,0 +1,132 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"menteslibres.net/luminos/logger"
+)
+
+// drainHandler wraps a handler and keeps track of the requests that are
+// currently being served, so we can wait for them before exiting.
+type drainHandler struct {
+	http.Handler
+	active sync.WaitGroup
+	// Guards draining, so no request is added while waiting.
+	mu sync.Mutex
+	// True once Wait was called.
+	draining bool
+}
+
+// newDrainHandler returns a drainHandler that wraps h.
+func newDrainHandler(h http.Handler) *drainHandler {
+	return &drainHandler{Handler: h}
+}
+
+// ServeHTTP counts the request as active while the wrapped handler serves it.
+// Requests that arrive while draining, like those sent through FastCGI
+// connections that are still open, are rejected.
+func (d *drainHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d.mu.Lock()
+	if d.draining {
+		d.mu.Unlock()
+		w.Header().Set("Connection", "close")
+		http.Error(w, "Server is shutting down.", http.StatusServiceUnavailable)
+		return
+	}
+	d.active.Add(1)
+	d.mu.Unlock()
+
+	defer d.active.Done()
+	d.Handler.ServeHTTP(w, req)
+}
+
+// Wait blocks until all active requests are finished or until the context is
+// done, whatever happens first. No new requests are served after calling it.
+func (d *drainHandler) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+
+	go func() {
+		d.active.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdown stops accepting new connections, waits for active requests to
+// finish and releases hosts and unix sockets. It returns cause, the reason why
+// the server was stopped.
+func shutdown(services []*service, handler *drainHandler, cause error) error {
+	timeout := time.Duration(settings.Server.ShutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Event streams never finish on their own.
+	if liveReload != nil {
+		liveReload.Close()
+	}
+
+	// No new connections will be accepted after this. Services are closed
+	// together, closing an HTTP service waits for its connections and the
+	// others must not keep accepting meanwhile.
+	var closing sync.WaitGroup
+
+	for _, s := range services {
+		closing.Add(1)
+		go func(s *service) {
+			defer closing.Done()
+			if err := s.close(ctx); err != nil {
+				logger.Errorf("Could not close %s: %q", s, err)
+			}
+		}(s)
+	}
+
+	closing.Wait()
+
+	// FastCGI connections are not tracked by the server, we wait for them here.
+	if err := handler.Wait(ctx); err != nil {
+		logger.Warnf("Some requests were not finished after %v: %q", timeout, err)
+	}
+
+	closeHosts()
+
+	for _, s := range services {
+		s.cleanup()
+	}
+
+	return cause
+}
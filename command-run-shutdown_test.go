@@ -0,0 +1,209 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"menteslibres.net/luminos/config"
+)
+
+// slowHandler answers after release is closed, started receives a value when
+// a request arrives.
+type slowHandler struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newSlowHandler() *slowHandler {
+	return &slowHandler{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (h *slowHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	h.started <- struct{}{}
+	<-h.release
+	w.Write([]byte("done"))
+}
+
+// startShutdownTest serves handler on a new HTTP service, sends a request to
+// it and waits for the request to arrive. The response is sent to the
+// returned channel.
+func startShutdownTest(t *testing.T, timeout time.Duration, h *slowHandler) (*drainHandler, []*service, chan string) {
+	s := newTestServer(t, nil)
+	s.config.Server.ShutdownTimeout = config.Duration(timeout)
+
+	prev := settings
+	settings = s.config
+
+	t.Cleanup(func() {
+		settings = prev
+		s.Close()
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := newDrainHandler(h)
+	services := []*service{newService("standalone", l, handler, nil)}
+
+	go services[0].serve()
+
+	responses := make(chan string, 1)
+
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String() + "/")
+		if err != nil {
+			responses <- err.Error()
+			return
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		responses <- string(body)
+	}()
+
+	<-h.started
+
+	return handler, services, responses
+}
+
+func TestShutdownWaitsForRequests(t *testing.T) {
+	h := newSlowHandler()
+
+	handler, services, responses := startShutdownTest(t, 10*time.Second, h)
+
+	// An idle listener, closed after the busy one.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	services = append(services, newService("standalone", l, handler, nil))
+	go services[1].serve()
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- shutdown(services, handler, nil)
+	}()
+
+	// Every listener stops accepting while requests are drained.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("Expecting the idle listener to be closed while draining.")
+		}
+	}
+
+	select {
+	case <-stopped:
+		t.Fatal("Expecting shutdown to wait for the active request.")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(h.release)
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expecting shutdown to finish after the request.")
+	}
+
+	if body := <-responses; body != "done" {
+		t.Fatalf("Expecting the request to be finished, got %q.", body)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	h := newSlowHandler()
+	defer close(h.release)
+
+	handler, services, _ := startShutdownTest(t, 200*time.Millisecond, h)
+
+	start := time.Now()
+
+	if err := shutdown(services, handler, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Fatalf("Expecting shutdown to give up after the timeout, took %v.", elapsed)
+	}
+}
+
+func TestDrainHandler(t *testing.T) {
+	h := newSlowHandler()
+	handler := newDrainHandler(h)
+
+	go handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	<-h.started
+
+	waited := make(chan error, 1)
+	go func() {
+		waited <- handler.Wait(context.Background())
+	}()
+
+	for draining := false; !draining; {
+		time.Sleep(time.Millisecond)
+		handler.mu.Lock()
+		draining = handler.draining
+		handler.mu.Unlock()
+	}
+
+	// Requests that arrive while draining, like those on open FastCGI
+	// connections, are rejected instead of being added to the wait.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expecting 503 while draining, got %d.", w.Code)
+	}
+
+	select {
+	case <-waited:
+		t.Fatal("Expecting Wait to block until the active request is finished.")
+	default:
+	}
+
+	close(h.release)
+
+	if err := <-waited; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if err := handler.Wait(ctx); err != nil {
+		t.Fatalf("Expecting no active requests, got %q.", err)
+	}
+}
@@ -0,0 +1,295 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/logger"
+	"menteslibres.net/luminos/router"
+)
+
+// Default HTTPS port, it's omitted from redirection URLs.
+const envHTTPSPort = 443
+
+// Accepted values for server.tls.min_version.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Cipher suites for the "modern" policy: forward secrecy and AEAD only. TLS
+// 1.3 suites are not configurable and are always enabled.
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+}
+
+// certificateStore holds the certificates for all TLS enabled hosts.
+type certificateStore struct {
+	// Settings the certificates were read from.
+	section *config.TLSConfig
+	// Certificate to use when SNI does not match any host.
+	fallback *tls.Certificate
+	// Certificates by host name.
+	hosts map[string]*tls.Certificate
+	// Obtains certificates for hosts without a certificate, if ACME is
+	// enabled.
+	acme *autocert.Manager
+	// Guards fallback and hosts, which are replaced on reload.
+	mu sync.RWMutex
+}
+
+// Certificate stores of all listeners, their files are read again on reload.
+var certificateStores struct {
+	sync.Mutex
+	stores []*certificateStore
+}
+
+// loadCertificates reads the certificate files of the store. Certificates are
+// only replaced if all of them can be read.
+func (c *certificateStore) loadCertificates() error {
+	var fallback *tls.Certificate
+	var err error
+
+	section := c.section
+
+	if section.Cert != "" || section.Key != "" {
+		if fallback, err = loadCertificate(section.CertificateConfig); err != nil {
+			return err
+		}
+	}
+
+	hosts := make(map[string]*tls.Certificate)
+
+	for name, entry := range section.Hosts {
+		r, err := router.Parse(name)
+		if err != nil {
+			return err
+		}
+		if hosts[r.Host], err = loadCertificate(entry); err != nil {
+			return fmt.Errorf("Failed to load certificate for host %s: %q", name, err)
+		}
+	}
+
+	c.mu.Lock()
+	c.fallback, c.hosts = fallback, hosts
+	c.mu.Unlock()
+
+	return nil
+}
+
+// reloadCertificates reads the certificate files of every listener again, so
+// renewed certificates are used without a restart. Listeners whose files
+// can't be read keep their current certificates.
+func reloadCertificates() error {
+	certificateStores.Lock()
+	defer certificateStores.Unlock()
+
+	var failed error
+	for _, store := range certificateStores.stores {
+		if err := store.loadCertificates(); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// lookup returns the certificate of a host name. Like routes, wildcard entries
+// match subdomains at any depth, and the longest one wins.
+func (c *certificateStore) lookup(name string) (*tls.Certificate, bool) {
+	if cert, ok := c.hosts[name]; ok {
+		return cert, true
+	}
+	for i := strings.Index(name, "."); i > 0; i = strings.Index(name, ".") {
+		name = name[i+1:]
+		if cert, ok := c.hosts["*."+name]; ok {
+			return cert, true
+		}
+	}
+	return nil, false
+}
+
+// getCertificate selects a certificate using the server name the client asked
+// for.
+func (c *certificateStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	c.mu.RLock()
+	cert, ok := c.lookup(name)
+	fallback := c.fallback
+	c.mu.RUnlock()
+
+	if ok {
+		return cert, nil
+	}
+
+	if c.acme != nil {
+		// TLS-ALPN-01 challenges are also answered by the manager.
+		cert, err := c.acme.GetCertificate(hello)
+		if err == nil || fallback == nil {
+			return cert, err
+		}
+		logger.Warnf("Could not get ACME certificate for %q, using default: %q", hello.ServerName, err)
+	}
+
+	if fallback != nil {
+		return fallback, nil
+	}
+
+	return nil, fmt.Errorf("No certificate for %q.", hello.ServerName)
+}
+
+// loadCertificate reads a certificate and its key.
+func loadCertificate(c config.CertificateConfig) (*tls.Certificate, error) {
+	if c.Cert == "" || c.Key == "" {
+		return nil, errors.New("Expecting \"cert\" and \"key\" entries.")
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load key pair (%s, %s): %q", c.Cert, c.Key, err)
+	}
+
+	return &cert, nil
+}
+
+// cipherSuites returns the cipher suites that match the server.tls.ciphers
+// setting. It accepts a policy name ("default" or "modern") or a list of cipher
+// suite names.
+func cipherSuites(c config.Ciphers) ([]uint16, error) {
+	if len(c.Suites) > 0 {
+		known := map[string]uint16{}
+		for _, suite := range tls.CipherSuites() {
+			known[suite.Name] = suite.ID
+		}
+		ids := make([]uint16, 0, len(c.Suites))
+		for _, name := range c.Suites {
+			id, ok := known[name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown or insecure cipher suite %q.", name)
+			}
+			ids = append(ids, id)
+		}
+		return ids, nil
+	}
+
+	switch c.Policy {
+	case "", "default":
+		return nil, nil
+	case "modern":
+		return modernCipherSuites, nil
+	}
+
+	return nil, fmt.Errorf("Unknown cipher policy %q.", c.Policy)
+}
+
+// tlsConfig reads the "tls" section of a listener. It returns a nil config if
+// TLS is not enabled, and a nil manager if ACME is not enabled.
+func tlsConfig(section *config.TLSConfig) (*tls.Config, *autocert.Manager, error) {
+	var err error
+	var ok bool
+
+	if section == nil {
+		return nil, nil, nil
+	}
+
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if v := section.MinVersion; v != "" {
+		if conf.MinVersion, ok = tlsVersions[v]; !ok {
+			return nil, nil, fmt.Errorf("Unknown TLS version %q.", v)
+		}
+	}
+
+	if conf.CipherSuites, err = cipherSuites(section.Ciphers); err != nil {
+		return nil, nil, err
+	}
+
+	store := &certificateStore{
+		section: section,
+	}
+
+	if err = store.loadCertificates(); err != nil {
+		return nil, nil, err
+	}
+
+	hosts := currentHostTable().hosts
+
+	for name := range section.Hosts {
+		if _, ok := hosts[name]; !ok {
+			logger.Warnf("TLS settings given for %s, but it's not in the hosts map.", name)
+		}
+	}
+
+	if section.ACME != nil {
+		if store.acme, err = newACMEManager(section.ACME); err != nil {
+			return nil, nil, fmt.Errorf("Failed to configure ACME: %q", err)
+		}
+		conf.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+	}
+
+	if store.fallback == nil && len(store.hosts) == 0 && store.acme == nil {
+		return nil, nil, errors.New("TLS was enabled but no certificates were given.")
+	}
+
+	conf.GetCertificate = store.getCertificate
+
+	certificateStores.Lock()
+	certificateStores.stores = append(certificateStores.stores, store)
+	certificateStores.Unlock()
+
+	return conf, store.acme, nil
+}
+
+// redirectHandler returns a handler that sends clients to the HTTPS version of
+// the requested URL.
+func redirectHandler(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := req.Host
+		if h, _, err := net.SplitHostPort(name); err == nil {
+			name = h
+		}
+		if port != envHTTPSPort {
+			name = net.JoinHostPort(name, strconv.Itoa(port))
+		}
+		http.Redirect(w, req, "https://"+name+req.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
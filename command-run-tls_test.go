@@ -0,0 +1,334 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"menteslibres.net/luminos/config"
+)
+
+// writeTestCertificate writes a self-signed certificate for the given host
+// names and its key to dir, and returns its settings.
+func writeTestCertificate(t *testing.T, dir string, name string, hosts ...string) config.CertificateConfig {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     hosts,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := config.CertificateConfig{
+		Cert: filepath.Join(dir, name+".crt"),
+		Key:  filepath.Join(dir, name+".key"),
+	}
+
+	if err := ioutil.WriteFile(c.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(c.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// commonName returns the name a certificate was issued to.
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCipherSuites(t *testing.T) {
+	suites, err := cipherSuites(config.Ciphers{})
+	if err != nil || suites != nil {
+		t.Fatalf("Expecting Go defaults, got %v, %v", suites, err)
+	}
+
+	suites, err = cipherSuites(config.Ciphers{Policy: "default"})
+	if err != nil || suites != nil {
+		t.Fatalf("Expecting Go defaults, got %v, %v", suites, err)
+	}
+
+	suites, err = cipherSuites(config.Ciphers{Policy: "modern"})
+	if err != nil || !reflect.DeepEqual(suites, modernCipherSuites) {
+		t.Fatalf("Expecting modern suites, got %v, %v", suites, err)
+	}
+
+	suites, err = cipherSuites(config.Ciphers{Suites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}})
+	if err != nil || !reflect.DeepEqual(suites, []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}) {
+		t.Fatalf("Expecting the named suite, got %v, %v", suites, err)
+	}
+
+	bad := []config.Ciphers{
+		{Policy: "paranoid"},
+		{Suites: []string{"TLS_NO_SUCH_SUITE"}},
+		{Suites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+	}
+
+	for _, c := range bad {
+		if _, err := cipherSuites(c); err == nil {
+			t.Fatalf("Expecting an error for %v.", c)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+
+	conf, manager, err := tlsConfig(nil)
+	if conf != nil || manager != nil || err != nil {
+		t.Fatalf("Expecting no TLS, got %v, %v, %v", conf, manager, err)
+	}
+
+	if _, _, err = tlsConfig(&config.TLSConfig{}); err == nil {
+		t.Fatal("Expecting an error without certificates.")
+	}
+
+	example := writeTestCertificate(t, s.root, "example.org", "example.org")
+
+	conf, manager, err = tlsConfig(&config.TLSConfig{
+		MinVersion: "1.3",
+		Ciphers:    config.Ciphers{Policy: "modern"},
+		Hosts:      map[string]config.CertificateConfig{"example.org": example},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manager != nil {
+		t.Fatal("Expecting no ACME manager.")
+	}
+	if conf.MinVersion != tls.VersionTLS13 {
+		t.Fatalf("Expecting TLS 1.3, got %x.", conf.MinVersion)
+	}
+	if !reflect.DeepEqual(conf.CipherSuites, modernCipherSuites) {
+		t.Fatalf("Expecting modern suites, got %v.", conf.CipherSuites)
+	}
+
+	for _, name := range []string{"example.org", "EXAMPLE.org", "example.org."} {
+		cert, err := conf.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cn := commonName(t, cert); cn != "example.org" {
+			t.Fatalf("Expecting example.org certificate for %q, got %q.", name, cn)
+		}
+	}
+
+	if _, err = conf.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.org"}); err == nil {
+		t.Fatal("Expecting an error for a host without certificate.")
+	}
+
+	fallback := writeTestCertificate(t, s.root, "default")
+
+	conf, _, err = tlsConfig(&config.TLSConfig{
+		CertificateConfig: fallback,
+		Hosts:             map[string]config.CertificateConfig{"example.org": example},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("Expecting TLS 1.2, got %x.", conf.MinVersion)
+	}
+
+	for name, expected := range map[string]string{"example.org": "example.org", "other.org": "default", "": "default"} {
+		cert, err := conf.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cn := commonName(t, cert); cn != expected {
+			t.Fatalf("Expecting %q certificate for %q, got %q.", expected, name, cn)
+		}
+	}
+
+	if _, _, err = tlsConfig(&config.TLSConfig{MinVersion: "2.0", CertificateConfig: fallback}); err == nil {
+		t.Fatal("Expecting an error for an unknown TLS version.")
+	}
+
+	if _, _, err = tlsConfig(&config.TLSConfig{CertificateConfig: config.CertificateConfig{Cert: fallback.Cert}}); err == nil {
+		t.Fatal("Expecting an error for a certificate without key.")
+	}
+
+	missing := config.CertificateConfig{Cert: s.path("missing.crt"), Key: s.path("missing.key")}
+	if _, _, err = tlsConfig(&config.TLSConfig{Hosts: map[string]config.CertificateConfig{"example.org": missing}}); err == nil {
+		t.Fatal("Expecting an error for a missing certificate.")
+	}
+}
+
+func TestWildcardCertificate(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+
+	conf, _, err := tlsConfig(&config.TLSConfig{
+		Hosts: map[string]config.CertificateConfig{
+			"example.org":        writeTestCertificate(t, s.root, "example.org", "example.org"),
+			"*.example.org":      writeTestCertificate(t, s.root, "wildcard", "*.example.org"),
+			"*.docs.example.org": writeTestCertificate(t, s.root, "docs", "*.docs.example.org"),
+			"*.example.com:8443": writeTestCertificate(t, s.root, "port", "*.example.com"),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"example.org":         "example.org",
+		"foo.example.org":     "wildcard",
+		"FOO.example.org.":    "wildcard",
+		"a.b.example.org":     "wildcard",
+		"v1.docs.example.org": "docs",
+		"docs.example.org":    "wildcard",
+		"www.example.com":     "port",
+	}
+
+	for name, expected := range tests {
+		cert, err := conf.GetCertificate(&tls.ClientHelloInfo{ServerName: name})
+		if err != nil {
+			t.Fatalf("%s: %q", name, err)
+		}
+		if cn := commonName(t, cert); cn != expected {
+			t.Fatalf("Expecting %q certificate for %q, got %q.", expected, name, cn)
+		}
+	}
+
+	for _, name := range []string{"example.net", "org", "fooexample.org"} {
+		if _, err := conf.GetCertificate(&tls.ClientHelloInfo{ServerName: name}); err == nil {
+			t.Fatalf("Expecting no certificate for %q.", name)
+		}
+	}
+}
+
+func TestReloadCertificates(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+
+	// Forget the stores of other tests, their files are gone.
+	certificateStores.Lock()
+	certificateStores.stores = nil
+	certificateStores.Unlock()
+
+	writeTestCertificate(t, s.root, "example.org", "example.org")
+
+	conf, _, err := tlsConfig(&config.TLSConfig{
+		Hosts: map[string]config.CertificateConfig{
+			"example.org": {Cert: s.path("example.org.crt"), Key: s.path("example.org.key")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello := &tls.ClientHelloInfo{ServerName: "example.org"}
+
+	before, err := conf.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A broken certificate keeps the current one.
+	s.write(map[string]string{"example.org.crt": "broken"})
+
+	if err := reloadCertificates(); err == nil {
+		t.Fatal("Expecting an error for a broken certificate.")
+	}
+
+	if cert, _ := conf.GetCertificate(hello); cert != before {
+		t.Fatal("Expecting the current certificate to be kept.")
+	}
+
+	// A renewed certificate replaces it.
+	writeTestCertificate(t, s.root, "example.org", "example.org")
+
+	if err := reloadCertificates(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := conf.GetCertificate(hello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == before || reflect.DeepEqual(after.Certificate, before.Certificate) {
+		t.Fatal("Expecting the renewed certificate.")
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		port   int
+		url    string
+		target string
+	}{
+		{443, "http://example.org/", "https://example.org/"},
+		{443, "http://example.org:8080/a/b?c=d", "https://example.org/a/b?c=d"},
+		{8443, "http://example.org/a", "https://example.org:8443/a"},
+		{8443, "http://[::1]:8080/", "https://[::1]:8443/"},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		redirectHandler(test.port).ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+
+		if w.Code != http.StatusMovedPermanently {
+			t.Fatalf("Expecting 301 for %s, got %d.", test.url, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != test.target {
+			t.Fatalf("Expecting %s to redirect to %s, got %s.", test.url, test.target, location)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+
+	"menteslibres.net/luminos/logger"
+)
+
+// Signal that starts a binary upgrade.
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+// notifyParent tells the process that started us with an upgrade that we are
+// ready to accept connections, so it can shut down gracefully.
+func notifyParent() {
+	value := os.Getenv(envUpgradeVariable)
+	if value == "" {
+		return
+	}
+
+	os.Unsetenv(envUpgradeVariable)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		logger.Errorf("Invalid %s value %q.", envUpgradeVariable, value)
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "upgrade")
+	defer f.Close()
+
+	logger.Infof("Ready, asking the old process %d to shut down.", os.Getppid())
+
+	if _, err := f.Write([]byte(upgradeReady)); err != nil {
+		logger.Errorf("Could not notify process %d: %q", os.Getppid(), err)
+	}
+}
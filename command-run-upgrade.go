@@ -0,0 +1,193 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"menteslibres.net/luminos/logger"
+)
+
+// Environment variable that tells a child process that it was started by an
+// upgrade. Its value is the file descriptor the child must write upgradeReady
+// to once it's ready.
+const envUpgradeVariable = "LUMINOS_UPGRADE"
+
+// Message that a child process sends to its parent when it's ready.
+const upgradeReady = "ready\n"
+
+// upgradeState guards against running more than one upgrade at the same time.
+var upgradeState struct {
+	sync.Mutex
+	running bool
+}
+
+// filer is implemented by listeners that can return their underlying file.
+type filer interface {
+	File() (*os.File, error)
+}
+
+// writePidFile writes the PID of the current process to the given file.
+func writePidFile(file string) error {
+	if file == "" {
+		return nil
+	}
+	return ioutil.WriteFile(file, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// removePidFile removes the given PID file, but only if it still holds our
+// PID. After an upgrade the file belongs to the new process.
+func removePidFile(file string) {
+	if file == "" {
+		return
+	}
+
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	if strings.TrimSpace(string(buf)) != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	if err = os.Remove(file); err != nil {
+		logger.Errorf("Could not remove PID file %s: %q", file, err)
+	}
+}
+
+// upgrade starts a new process using the current executable (which may have
+// been replaced on disk) and passes it our listening sockets. The new process
+// notifies us through a pipe when it's ready to accept connections, then
+// ready receives a value so we can drain and exit. If the new process exits
+// before that, we keep running as if nothing happened.
+func upgrade(services []*service, ready chan<- struct{}) error {
+	upgradeState.Lock()
+	defer upgradeState.Unlock()
+
+	if upgradeState.running {
+		return errors.New("An upgrade is already in progress.")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		if executable, err = exec.LookPath(os.Args[0]); err != nil {
+			return fmt.Errorf("Could not find executable: %q", err)
+		}
+	}
+
+	files := make([]*os.File, 0, len(services))
+	names := make([]string, 0, len(services))
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, s := range services {
+		l, ok := s.listener.(filer)
+		if !ok {
+			return fmt.Errorf("Cannot pass %s to a new process.", s)
+		}
+		f, err := l.File()
+		if err != nil {
+			return fmt.Errorf("Cannot pass %s to a new process: %q", s, err)
+		}
+		files = append(files, f)
+
+		name := s.name
+		if name == "" {
+			name = "unknown"
+		}
+		names = append(names, name)
+	}
+
+	// The new process writes to w when it's ready, and closes it when it
+	// exits.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("Could not create pipe: %q", err)
+	}
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = append(os.Environ(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		envUpgradeVariable+"="+strconv.Itoa(listenFdsStart+len(files)),
+	)
+
+	err = cmd.Start()
+	w.Close()
+
+	if err != nil {
+		r.Close()
+		return fmt.Errorf("Could not start %s: %q", executable, err)
+	}
+
+	logger.Infof("Started new process %d (%s), waiting for it to be ready.", cmd.Process.Pid, executable)
+
+	upgradeState.running = true
+
+	go func() {
+		defer r.Close()
+
+		if line, _ := bufio.NewReader(r).ReadString('\n'); line == upgradeReady {
+			logger.Infof("Process %d is ready.", cmd.Process.Pid)
+			keepSockets(services)
+			ready <- struct{}{}
+			return
+		}
+
+		err := cmd.Wait()
+		logger.Errorf("Process %d exited before it was ready: %v", cmd.Process.Pid, err)
+
+		upgradeState.Lock()
+		upgradeState.running = false
+		upgradeState.Unlock()
+	}()
+
+	return nil
+}
+
+// keepSockets makes sure unix socket files are not removed when we exit,
+// they're in use by the new process.
+func keepSockets(services []*service) {
+	for _, s := range services {
+		s.keepSocket = true
+		if l, ok := s.listener.(*net.UnixListener); ok {
+			l.SetUnlinkOnClose(false)
+		}
+	}
+}
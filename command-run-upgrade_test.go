@@ -0,0 +1,220 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Environment variable that makes TestUpgradeChild act as the new process of
+// an upgrade: "serve" or "fail".
+const envTestUpgrade = "LUMINOS_TEST_UPGRADE"
+
+// TestUpgradeChild is started by TestUpgrade, it serves the sockets it
+// inherited and tells whether they are managed.
+func TestUpgradeChild(t *testing.T) {
+	mode := os.Getenv(envTestUpgrade)
+	if mode == "" {
+		return
+	}
+
+	if mode == "fail" {
+		os.Exit(1)
+	}
+
+	if err := loadInheritedListeners(); err != nil {
+		t.Fatal(err)
+	}
+
+	exit := make(chan struct{})
+
+	for _, l := range inherited {
+		l := l
+		go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprintf(w, "%s managed=%v", l.name, l.managed)
+			if req.URL.Path == "/exit" {
+				close(exit)
+			}
+		}))
+	}
+
+	notifyParent()
+
+	select {
+	case <-exit:
+		time.Sleep(100 * time.Millisecond)
+	case <-time.After(10 * time.Second):
+	}
+}
+
+// startUpgrade runs upgrade with TestUpgradeChild as the new process.
+func startUpgrade(t *testing.T, mode string, services []*service, ready chan struct{}) {
+	args := os.Args
+	defer func() {
+		os.Args = args
+		os.Unsetenv(envTestUpgrade)
+	}()
+
+	os.Args = []string{args[0], "-test.run=^TestUpgradeChild$"}
+	os.Setenv(envTestUpgrade, mode)
+
+	if err := upgrade(services, ready); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// testListeners creates a TCP and a unix socket service.
+func testListeners(t *testing.T, dir string) []*service {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unix, err := net.Listen("unix", filepath.Join(dir, "luminos.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []*service{
+		{listener: tcp, name: "web"},
+		{listener: unix, name: "sock"},
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luminos-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	services := testListeners(t, dir)
+	ready := make(chan struct{}, 1)
+
+	// A real process would exit after a successful upgrade.
+	defer func() {
+		upgradeState.Lock()
+		upgradeState.running = false
+		upgradeState.Unlock()
+	}()
+
+	startUpgrade(t, "serve", services, ready)
+
+	select {
+	case <-ready:
+	case <-time.After(10 * time.Second):
+		t.Fatal("The new process did not become ready.")
+	}
+
+	if !services[0].keepSocket || !services[1].keepSocket {
+		t.Fatal("Expecting sockets to be kept after the new process is ready.")
+	}
+
+	// The old process goes away, the new one keeps serving the same sockets.
+	sock := services[1].listener.Addr().String()
+	for _, s := range services {
+		s.listener.Close()
+		s.cleanup()
+	}
+
+	if _, err := os.Stat(sock); err != nil {
+		t.Fatalf("Expecting the unix socket to be kept: %v", err)
+	}
+
+	get := func(client *http.Client, url string) string {
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return string(body)
+	}
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}}
+
+	if body := get(unixClient, "http://luminos/"); body != "sock managed=false" {
+		t.Fatalf("Unexpected answer from the new process: %q", body)
+	}
+
+	if body := get(http.DefaultClient, "http://"+services[0].listener.Addr().String()+"/exit"); body != "web managed=false" {
+		t.Fatalf("Unexpected answer from the new process: %q", body)
+	}
+}
+
+func TestUpgradeFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luminos-upgrade")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	services := testListeners(t, dir)
+	defer func() {
+		for _, s := range services {
+			s.listener.Close()
+		}
+	}()
+
+	ready := make(chan struct{}, 1)
+
+	startUpgrade(t, "fail", services, ready)
+
+	for i := 0; i < 100; i++ {
+		upgradeState.Lock()
+		running := upgradeState.running
+		upgradeState.Unlock()
+		if !running {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case <-ready:
+		t.Fatal("The new process was not expected to become ready.")
+	default:
+	}
+
+	upgradeState.Lock()
+	running := upgradeState.running
+	upgradeState.Unlock()
+
+	if running || services[0].keepSocket || services[1].keepSocket {
+		t.Fatal("Expecting the upgrade to be undone.")
+	}
+}
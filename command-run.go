@@ -25,15 +25,14 @@ import (
 	"flag"
 	"fmt"
 	//"github.com/howeyc/fsnotify"
-	"log"
-	"net"
-	"net/http"
-	"net/http/fcgi"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"menteslibres.net/gosexy/cli"
-	"menteslibres.net/gosexy/to"
-	"menteslibres.net/gosexy/yaml"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/livereload"
+	"menteslibres.net/luminos/logger"
 )
 
 // Default values
@@ -44,28 +43,52 @@ const (
 )
 
 // Global software settings.
-var settings *yaml.Yaml
+var settings *config.ServerConfig
 
 // Command line settings.
-var flagSettings = flag.String("c", envSettingsFile, "Path to the settings.yaml file.")
+var (
+	flagSettings = flag.String("c", envSettingsFile, "Path to the settings.yaml file.")
+	flagDev      = flag.Bool("dev", false, "Development mode: reload pages in the browser when files change.")
+	flagLogLevel = flag.String("log-level", "", "Minimum level of log messages: debug, info, warn or error.")
+)
 
 // runCommand is the structure that provides instructions for the "luminos
 // run" subcommand.
 type runCommand struct {
 }
 
-// Execute runs a luminos server using a settings file.
+// Execute runs a luminos server using a settings file, or serves the given
+// directory if there is one.
 func (c *runCommand) Execute() (err error) {
 	var stat os.FileInfo
 
+	f := newRunFlags("run")
+
+	if err = f.parse(flag.Args()[1:]); err != nil {
+		return err
+	}
+
+	// A directory does not need a settings file.
+	if f.NArg() > 0 {
+		return serveDirectory(f.Arg(0))
+	}
+
 	// If no settings file was specified, use the default.
 	if *flagSettings == "" {
 		*flagSettings = envSettingsFile
 	}
 
+	// Settings may also be written in JSON or TOML.
+	*flagSettings = config.FindFile(*flagSettings)
+
 	// Attempt to stat the settings file.
 	stat, err = os.Stat(*flagSettings)
 
+	// Hosts given by flags or environment do not need a settings file.
+	if os.IsNotExist(err) && hasHostOverrides() {
+		return serveSettings(defaultSettings())
+	}
+
 	// It must not return an error.
 	if err != nil {
 		return fmt.Errorf("Error while opening %s: %q", *flagSettings, err)
@@ -81,59 +104,134 @@ func (c *runCommand) Execute() (err error) {
 		return fmt.Errorf("Could not open %s: it's a directory!", *flagSettings)
 	}
 
+	initLiveReload()
+
 	// Now that we're positively sure that we have a valid file, let's try to
 	// read settings from it.
 	if settings, err = loadSettings(*flagSettings); err != nil {
 		return fmt.Errorf("Error while reading settings file %s: %q", *flagSettings, err)
 	}
 
-	// Starting settings watcher.
-	if err = settingsWatcher(); err == nil {
-		watch.Watch(*flagSettings)
+	return runServer()
+}
+
+// initLiveReload creates the live reload hub in development mode, it must be
+// called before loading hosts.
+func initLiveReload() {
+	// In development mode, pages reload themselves when files change.
+	if *flagDev {
+		liveReload = livereload.New()
+		logger.Infof("Development mode, pages will reload when files change.")
+	}
+}
+
+// runServer starts the listeners given by the current settings and serves
+// hosts until a termination signal is received.
+func runServer() (err error) {
+	// Requests must be tracked so we can wait for them before exiting.
+	handler := newDrainHandler(&server{})
+
+	// Sockets passed by a service manager, like systemd.
+	if err = loadInheritedListeners(); err != nil {
+		return err
 	}
 
-	// Reading setttings.
-	serverType := to.String(settings.Get("server", "type"))
+	// Creating network listeners, all of them share the same hosts.
+	var services []*service
 
-	domain := envServerDomain
-	address := to.String(settings.Get("server", "socket"))
+	for i, entry := range settings.ListenerList() {
+		var started []*service
 
-	if address == "" {
-		domain = envServerProtocol
-		address = fmt.Sprintf("%s:%d", to.String(settings.Get("server", "bind")), to.Int64(settings.Get("server", "port")))
+		if started, err = listen(entry, handler); err != nil {
+			for _, s := range services {
+				s.listener.Close()
+				s.cleanup()
+			}
+			return fmt.Errorf("Could not start listener #%d: %q", i+1, err)
+		}
+
+		services = append(services, started...)
+	}
+
+	// Optional admin listener, for metrics.
+	if admin := settings.Server.Admin; admin != nil {
+		s, err := listenAdmin(admin)
+		if err != nil {
+			for _, s := range services {
+				s.listener.Close()
+				s.cleanup()
+			}
+			return err
+		}
+		services = append(services, s)
 	}
 
-	// Creating a network listener.
-	var listener net.Listener
+	closeUnclaimedListeners()
+
+	// Errors returned by the server loops.
+	errc := make(chan error, len(services))
 
-	if listener, err = net.Listen(domain, address); err != nil {
-		return fmt.Errorf("Could not create network listener: %q", err)
+	for _, s := range services {
+		logger.Infof("Starting %s.", s)
+		go func(s *service) {
+			errc <- s.serve()
+		}(s)
 	}
 
-	// Listener must be closed when the function exits.
-	defer listener.Close()
+	// Writing PID file.
+	pidFile := settings.Server.PidFile
 
-	// Attempt to start a server.
-	switch serverType {
-	case "fastcgi":
-		if err == nil {
-			log.Printf("Starting FastCGI server. Listening at %s.\n", address)
-			fcgi.Serve(listener, &server{})
-		} else {
-			return fmt.Errorf("Failed to start FastCGI server: %q", err)
-		}
-	case "standalone":
-		if err == nil {
-			log.Printf("Starting HTTP server. Listening at %s.\n", address)
-			http.Serve(listener, &server{})
-		} else {
-			return fmt.Errorf("Failed to start HTTP server: %q", err)
-		}
-	default:
-		return fmt.Errorf("Unknown server type: %s", serverType)
+	if err = writePidFile(pidFile); err != nil {
+		logger.Errorf("Could not write PID file %s: %q", pidFile, err)
 	}
 
-	return nil
+	defer removePidFile(pidFile)
+
+	// If we were started by an upgrade, the old process can go away now.
+	notifyParent()
+
+	// Receives a value when a new process started by an upgrade is ready.
+	readyc := make(chan struct{}, 1)
+
+	// Waiting for a termination signal.
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, reloadSignal)
+	if upgradeSignal != nil {
+		signal.Notify(sigc, upgradeSignal)
+	}
+	if reopenSignal != nil {
+		signal.Notify(sigc, reopenSignal)
+	}
+	defer signal.Stop(sigc)
+
+	for {
+		select {
+		case sig := <-sigc:
+			if sig == reloadSignal {
+				logger.Infof("Got signal %v, reloading.", sig)
+				reload()
+				continue
+			}
+			if sig == reopenSignal {
+				logger.Infof("Got signal %v, reopening log files.", sig)
+				reopenLogFiles()
+				continue
+			}
+			if sig == upgradeSignal {
+				logger.Infof("Got signal %v, upgrading.", sig)
+				if err := upgrade(services, readyc); err != nil {
+					logger.Errorf("Could not upgrade: %q", err)
+				}
+				continue
+			}
+			logger.Infof("Got signal %v, shutting down.", sig)
+		case <-readyc:
+			logger.Infof("Upgraded, shutting down.")
+		case err = <-errc:
+			err = fmt.Errorf("Server stopped unexpectedly: %q", err)
+		}
+		return shutdown(services, handler, err)
+	}
 }
 
 func init() {
@@ -142,7 +240,8 @@ func init() {
 	cli.Register("run", cli.Entry{
 		Name:        "run",
 		Description: "Runs a luminos server.",
-		Arguments:   []string{"c"},
+		Usage:       "run [-bind ADDRESS] [-port PORT] [-socket PATH] [-type TYPE] [-host NAME=PATH]... [-dev] [-log-level LEVEL] [DIR]",
+		Arguments:   []string{"c", "dev", "log-level"},
 		Command:     &runCommand{},
 	})
 
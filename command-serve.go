@@ -0,0 +1,131 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"menteslibres.net/gosexy/cli"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/logger"
+	"menteslibres.net/luminos/router"
+)
+
+// Default values for serving a directory.
+const (
+	envServeBind = "127.0.0.1"
+	envServePort = 9000
+)
+
+// serveCommand is the structure that provides instructions for the "luminos
+// serve" subcommand.
+type serveCommand struct {
+}
+
+// defaultSettings returns settings for a local standalone server without
+// hosts.
+func defaultSettings() *config.ServerConfig {
+	c := config.NewServerConfig()
+
+	c.Server.Listener.Bind = envServeBind
+	c.Server.Listener.Port = envServePort
+
+	return c
+}
+
+// serveSettings serves hosts using settings that were not read from a file,
+// flags and environment variables are applied to them.
+func serveSettings(c *config.ServerConfig) (err error) {
+	initLiveReload()
+
+	applyOverrides(c)
+
+	if err = loadHosts(c); err != nil {
+		return err
+	}
+
+	settings = c
+
+	return runServer()
+}
+
+// directorySettings returns settings that serve a directory of markdown files
+// as the default host.
+func directorySettings(dir string) (*config.ServerConfig, error) {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Error while opening %s: %q", dir, err)
+	}
+
+	if stat.IsDir() == false {
+		return nil, fmt.Errorf("Could not serve %s: it's not a directory!", dir)
+	}
+
+	c := defaultSettings()
+	c.Hosts.Set(router.Default, filepath.Clean(dir))
+
+	return c, nil
+}
+
+// serveDirectory serves a directory of markdown files, it does not need
+// settings files nor templates.
+func serveDirectory(dir string) error {
+	c, err := directorySettings(dir)
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Serving directory %s.", dir)
+
+	return serveSettings(c)
+}
+
+// Execute serves the given directory, or the current one.
+func (c *serveCommand) Execute() error {
+	f := newRunFlags("serve")
+
+	if err := f.parse(flag.Args()[1:]); err != nil {
+		return err
+	}
+
+	dir := "."
+
+	if f.NArg() > 0 {
+		dir = f.Arg(0)
+	}
+
+	return serveDirectory(dir)
+}
+
+func init() {
+	// Describing the "serve" subcommand.
+	cli.Register("serve", cli.Entry{
+		Name:        "serve",
+		Description: "Serves a directory of markdown files without settings.",
+		Usage:       "serve [-bind ADDRESS] [-port PORT] [-host NAME=PATH]... [-dev] [-log-level LEVEL] [DIR]",
+		Arguments:   []string{"dev", "log-level"},
+		Command:     &serveCommand{},
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServeDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luminos-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFiles(t, dir, map[string]string{
+		"index.md":         "# Notes\n",
+		"docs/intro.md":    "# Intro\n",
+		"images/logo.png":  "png",
+		"_drafts/draft.md": "# Draft\n",
+	})
+
+	c, err := directorySettings(dir + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeHosts()
+
+	if err := loadHosts(c); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{t: t, root: dir, config: c}
+
+	// Pages use the default theme, the home page lists the directory.
+	body := s.get("http://localhost:9000/").Body.String()
+	for _, text := range []string{`class="sidebar"`, "Notes", `href="/docs"`} {
+		if !strings.Contains(body, text) {
+			t.Fatalf("Expecting %s on the home page, got %q.", text, body)
+		}
+	}
+	if strings.Contains(body, "_drafts") {
+		t.Fatalf("Expecting hidden directories not to be listed, got %q.", body)
+	}
+
+	if body := s.get("http://localhost:9000/docs/intro").Body.String(); !strings.Contains(body, "Intro") {
+		t.Fatalf("Expecting the intro page, got %q.", body)
+	}
+
+	// Other files are served next to the pages.
+	if w := s.get("http://localhost:9000/images/logo.png"); w.Code != 200 || w.Body.String() != "png" {
+		t.Fatalf("Expecting the image, got %d %q.", w.Code, w.Body.String())
+	}
+
+	if _, err := directorySettings(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("Expecting an error for a missing directory.")
+	}
+
+	if _, err := directorySettings(filepath.Join(dir, "index.md")); err == nil {
+		t.Fatal("Expecting an error for a file.")
+	}
+}
@@ -0,0 +1,290 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package compress negotiates gzip or deflate encodings with clients and
+// compresses responses of the given types while they are written.
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Encodings that can be negotiated.
+const (
+	Gzip    = "gzip"
+	Deflate = "deflate"
+)
+
+// DefaultTypes are the media types that are compressed by default.
+var DefaultTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/xml",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// Config sets which responses are compressed.
+type Config struct {
+	// Responses smaller than this number of bytes are sent as they are.
+	MinSize int
+	// Media types that are compressed, without parameters.
+	Types []string
+}
+
+// compressible returns true if responses of the given content type may be
+// compressed.
+func (c *Config) compressible(contentType string) bool {
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range c.Types {
+		if strings.EqualFold(t, media) {
+			return true
+		}
+	}
+	return false
+}
+
+// accepted returns the quality the Accept-Encoding header gives to an
+// encoding.
+func accepted(header string, encoding string) float64 {
+	q := -1.0
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name != encoding && name != "*" {
+			continue
+		}
+		value := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if f, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					value = f
+				}
+			}
+		}
+		// An explicit entry wins over the wildcard.
+		if name == encoding || q < 0 {
+			q = value
+		}
+		if name == encoding {
+			break
+		}
+	}
+	return q
+}
+
+// Accepts returns true if the client accepts the given encoding.
+func Accepts(req *http.Request, encoding string) bool {
+	return accepted(req.Header.Get("Accept-Encoding"), encoding) > 0
+}
+
+// Negotiate returns the encoding that should be used for a response to the
+// given request, gzip is preferred over deflate. An empty string means that
+// the response must not be compressed.
+func Negotiate(req *http.Request) string {
+	header := req.Header.Get("Accept-Encoding")
+	if header == "" {
+		return ""
+	}
+	gz, df := accepted(header, Gzip), accepted(header, Deflate)
+	switch {
+	case gz > 0 && gz >= df:
+		return Gzip
+	case df > 0:
+		return Deflate
+	}
+	return ""
+}
+
+// addVary adds Accept-Encoding to the Vary header, unless it's already there.
+func addVary(h http.Header) {
+	for _, v := range h["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(name), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// ResponseWriter compresses the body of a response, if the client accepts it
+// and the response is large enough and has one of the configured types. The
+// beginning of the body is kept until that can be decided. Close must be
+// called once the response has been written.
+type ResponseWriter struct {
+	http.ResponseWriter
+	config   *Config
+	encoding string
+	status   int
+	buf      bytes.Buffer
+	// Set once the header has been sent.
+	decided bool
+	enc     io.WriteCloser
+}
+
+// NewResponseWriter wraps w, the encoding is negotiated with req.
+func NewResponseWriter(w http.ResponseWriter, req *http.Request, c *Config) *ResponseWriter {
+	cw := &ResponseWriter{ResponseWriter: w, config: c}
+	if req.Method != "HEAD" {
+		cw.encoding = Negotiate(req)
+	}
+	return cw
+}
+
+// WriteHeader implements http.ResponseWriter. The status is sent along with
+// the first bytes of the body.
+func (w *ResponseWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	// Responses without a body are not compressed.
+	if status != http.StatusOK {
+		w.decide(false)
+	}
+}
+
+// Write implements http.ResponseWriter.
+func (w *ResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		if w.enc != nil {
+			return w.enc.Write(p)
+		}
+		return w.ResponseWriter.Write(p)
+	}
+	w.buf.Write(p)
+	if w.buf.Len() >= w.config.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush implements http.Flusher, if the wrapped writer does. Flushing sends
+// the header, compression is still used for large enough types.
+func (w *ResponseWriter) Flush() {
+	if w.status == 0 && w.buf.Len() == 0 {
+		return
+	}
+	w.decide(w.buf.Len() >= w.config.MinSize)
+	if f, ok := w.enc.(interface {
+		Flush() error
+	}); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the wrapped writer does. Hijacked
+// connections are never compressed.
+func (w *ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("The connection can't be hijacked.")
+	}
+	w.decided = true
+	return h.Hijack()
+}
+
+// Close sends whatever is left of the response.
+func (w *ResponseWriter) Close() error {
+	if err := w.decide(w.buf.Len() >= w.config.MinSize); err != nil {
+		return err
+	}
+	if w.enc != nil {
+		return w.enc.Close()
+	}
+	return nil
+}
+
+// decide sends the header, compressing the body if large is true and the
+// response can be compressed, and then the bytes that were kept.
+func (w *ResponseWriter) decide(large bool) error {
+	if w.decided {
+		return nil
+	}
+	w.decided = true
+
+	h := w.Header()
+
+	eligible := h.Get("Content-Encoding") == "" && h.Get("Content-Range") == ""
+	if eligible && w.status == http.StatusOK {
+		contentType := h.Get("Content-Type")
+		if contentType == "" && w.buf.Len() > 0 {
+			contentType = http.DetectContentType(w.buf.Bytes())
+			h.Set("Content-Type", contentType)
+		}
+		if w.config.compressible(contentType) {
+			addVary(h)
+			if large && w.encoding != "" {
+				h.Del("Content-Length")
+				h.Set("Content-Encoding", w.encoding)
+				if w.encoding == Gzip {
+					w.enc = gzip.NewWriter(w.ResponseWriter)
+				} else {
+					w.enc = zlib.NewWriter(w.ResponseWriter)
+				}
+			}
+		}
+	}
+
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(w.buf.Bytes())
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf.Bytes())
+	}
+	w.buf.Reset()
+	return err
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testConfig = &Config{MinSize: 64, Types: DefaultTypes}
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                      "",
+		"gzip":                  Gzip,
+		"deflate":               Deflate,
+		"deflate, gzip":         Gzip,
+		"gzip;q=0.5, deflate":   Deflate,
+		"gzip;q=0, deflate;q=0": "",
+		"*":                     Gzip,
+		"*;q=0, deflate":        Deflate,
+		"br, identity":          "",
+	}
+
+	for header, expected := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", header)
+		if encoding := Negotiate(req); encoding != expected {
+			t.Errorf("%q: expecting %q, got %q.", header, expected, encoding)
+		}
+	}
+}
+
+func serve(accept string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", accept)
+	rec := httptest.NewRecorder()
+	w := NewResponseWriter(rec, req, testConfig)
+	handler(w, req)
+	w.Close()
+	return rec
+}
+
+func TestResponseWriter(t *testing.T) {
+	page := "<html><body>" + strings.Repeat("Lorem ipsum dolor sit amet. ", 20) + "</body></html>"
+
+	html := func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, page)
+	}
+
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		Gzip: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+		Deflate: func(r io.Reader) (io.Reader, error) {
+			return zlib.NewReader(r)
+		},
+	}
+
+	for encoding, decoder := range decoders {
+		rec := serve(encoding, html)
+		if rec.Header().Get("Content-Encoding") != encoding || rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("Expecting a %s response, got %v.", encoding, rec.Header())
+		}
+		r, err := decoder(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != page {
+			t.Fatalf("Unexpected %s body: %q", encoding, body)
+		}
+	}
+
+	if rec := serve("", html); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != page {
+		t.Fatalf("Expecting an uncompressed response.")
+	}
+
+	small := func(w http.ResponseWriter, req *http.Request) {
+		io.WriteString(w, "<p>Hi</p>")
+	}
+	if rec := serve("gzip", small); rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "<p>Hi</p>" {
+		t.Fatalf("Small responses must not be compressed.")
+	}
+
+	image := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, page)
+	}
+	if rec := serve("gzip", image); rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Vary") != "" {
+		t.Fatalf("Unexpected compression of an image.")
+	}
+
+	encoded := func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.Header().Set("Content-Encoding", Gzip)
+		io.WriteString(w, page)
+	}
+	if rec := serve("gzip", encoded); rec.Body.String() != page {
+		t.Fatalf("Encoded responses must not be compressed again.")
+	}
+
+	missing := func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, page, http.StatusNotFound)
+	}
+	if rec := serve("gzip", missing); rec.Code != http.StatusNotFound || rec.Header().Get("Content-Encoding") != "" {
+		t.Fatalf("Expecting an uncompressed 404, got %d.", rec.Code)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Extensions of settings files, in order of preference. The parser is chosen
+// by extension, files with other extensions are read as YAML.
+var Extensions = []string{".yaml", ".json", ".toml"}
+
+// FindFile returns file if it exists. Otherwise it returns the first existing
+// file with the same name and another known extension, or file if there is no
+// such file.
+func FindFile(file string) string {
+	if _, err := os.Stat(file); err == nil {
+		return file
+	}
+
+	base := strings.TrimSuffix(file, filepath.Ext(file))
+
+	for _, ext := range Extensions {
+		if _, err := os.Stat(base + ext); err == nil {
+			return base + ext
+		}
+	}
+
+	return file
+}
+
+// IsYAML returns true if the file is read as YAML.
+func IsYAML(file string) bool {
+	switch filepath.Ext(file) {
+	case ".json", ".toml":
+		return false
+	}
+	return true
+}
+
+// lineAt returns the line of a byte offset.
+func lineAt(buf []byte, offset int64) int {
+	if offset > int64(len(buf)) {
+		offset = int64(len(buf))
+	}
+	return bytes.Count(buf[:offset], []byte("\n")) + 1
+}
+
+// encode converts a decoded value into a document, line numbers are lost.
+func encode(file string, v interface{}) (*Document, error) {
+	var node yaml.Node
+
+	if err := node.Encode(v); err != nil {
+		return nil, Problem{File: file, Message: err.Error()}
+	}
+
+	return &Document{File: file, root: &node}, nil
+}
+
+// parseJSON parses a JSON file.
+func parseJSON(file string, buf []byte) (*Document, error) {
+	var v interface{}
+
+	if err := json.Unmarshal(buf, &v); err != nil {
+		p := Problem{File: file, Message: err.Error()}
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			p.Line = lineAt(buf, syntaxErr.Offset)
+		}
+		return nil, p
+	}
+
+	// JSON is also YAML, reading it as YAML keeps line numbers. A few JSON
+	// files are not valid YAML though, like those indented with tabs.
+	if d, err := parseYAML(file, buf); err == nil {
+		return d, nil
+	}
+
+	return encode(file, v)
+}
+
+// parseTOML parses a TOML file, line numbers are only known for syntax errors.
+func parseTOML(file string, buf []byte) (*Document, error) {
+	v := make(map[string]interface{})
+
+	if _, err := toml.Decode(string(buf), &v); err != nil {
+		p := Problem{File: file, Message: err.Error()}
+		var parseErr toml.ParseError
+		if errors.As(err, &parseErr) {
+			p.Line = parseErr.Position.Line
+			p.Message = parseErr.Message
+		}
+		return nil, p
+	}
+
+	return encode(file, v)
+}
+
+// Values returns the document as a tree of maps and lists, like the ones
+// templates get from YAML files.
+func (d *Document) Values() map[interface{}]interface{} {
+	var v interface{}
+
+	if d.root != nil {
+		d.root.Decode(&v)
+	}
+
+	if m, ok := normalize(v).(map[interface{}]interface{}); ok {
+		return m
+	}
+
+	return make(map[interface{}]interface{})
+}
+
+// normalize converts maps into map[interface{}]interface{}.
+func normalize(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		m := make(map[interface{}]interface{}, len(t))
+		for k, value := range t {
+			m[k] = normalize(value)
+		}
+		return m
+	case map[interface{}]interface{}:
+		for k, value := range t {
+			t[k] = normalize(value)
+		}
+		return t
+	case []interface{}:
+		for i := range t {
+			t[i] = normalize(t[i])
+		}
+		return t
+	}
+	return v
+}
@@ -0,0 +1,390 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package config validates luminos settings files against a schema and
+// reports problems along with file names and line numbers. Settings files may
+// be written in YAML, JSON or TOML.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Matches line numbers on YAML syntax errors.
+var syntaxErrorPattern = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// Kind is the expected type of a value.
+type Kind int
+
+// Kinds of values.
+const (
+	// Any value is accepted.
+	Any Kind = iota
+	// A string.
+	String
+	// An integer.
+	Int
+	// A boolean.
+	Bool
+	// A map with known keys, see Schema.Keys.
+	Map
+	// A map with arbitrary keys, values are described by Schema.Elem.
+	Dict
+	// A list, elements are described by Schema.Elem.
+	List
+)
+
+var kindNames = map[Kind]string{
+	Any:    "any value",
+	String: "a string",
+	Int:    "an integer",
+	Bool:   "a boolean",
+	Map:    "a map",
+	Dict:   "a map",
+	List:   "a list",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// Schema describes the expected structure of a value.
+type Schema struct {
+	// Expected kind.
+	Kind Kind
+	// Alternative schemas, the value must match at least one of them.
+	Or []*Schema
+	// Known keys of a Map.
+	Keys map[string]*Schema
+	// Elements of a List or values of a Dict.
+	Elem *Schema
+	// Accepted values for strings, any string is accepted if empty.
+	Enum []string
+}
+
+// Problem is an issue found in a file.
+type Problem struct {
+	// File name.
+	File string
+	// Line number, 0 if unknown.
+	Line int
+	// Description of the problem.
+	Message string
+	// True if the problem does not prevent luminos from running.
+	Warning bool
+}
+
+// Error returns the problem as a "file:line: message" string.
+func (p Problem) Error() string {
+	level := "error"
+	if p.Warning {
+		level = "warning"
+	}
+	if p.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", p.File, p.Line, level, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", p.File, level, p.Message)
+}
+
+// ProblemFor returns err as a problem of the given file. Errors that are not
+// problems are reported without line.
+func ProblemFor(file string, err error) Problem {
+	var p Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	return Problem{File: file, Message: err.Error()}
+}
+
+// Problems is a list of problems sorted by file and line.
+type Problems []Problem
+
+func (l Problems) Len() int {
+	return len(l)
+}
+
+func (l Problems) Less(i, j int) bool {
+	if l[i].File != l[j].File {
+		return l[i].File < l[j].File
+	}
+	return l[i].Line < l[j].Line
+}
+
+func (l Problems) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+// Errors returns the number of problems that are not warnings.
+func (l Problems) Errors() int {
+	n := 0
+	for _, p := range l {
+		if !p.Warning {
+			n++
+		}
+	}
+	return n
+}
+
+// Err returns an error that describes the first error, or nil if all problems
+// are warnings.
+func (l Problems) Err() error {
+	for _, p := range l {
+		if !p.Warning {
+			if n := l.Errors(); n > 1 {
+				return fmt.Errorf("%s (and %d more errors)", p.Error(), n-1)
+			}
+			return p
+		}
+	}
+	return nil
+}
+
+// Document is a parsed YAML file that keeps line numbers.
+type Document struct {
+	// File name.
+	File string
+	root *yaml.Node
+}
+
+// Open parses a settings file. Syntax errors are returned as a Problem.
+func Open(file string) (*Document, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, Problem{File: file, Message: err.Error()}
+	}
+	return Parse(file, buf)
+}
+
+// Parse parses YAML, JSON or TOML data, depending on the extension of file.
+// The file name is also used for reporting problems.
+func Parse(file string, buf []byte) (*Document, error) {
+	switch filepath.Ext(file) {
+	case ".json":
+		return parseJSON(file, buf)
+	case ".toml":
+		return parseTOML(file, buf)
+	}
+	return parseYAML(file, buf)
+}
+
+// parseYAML parses YAML data.
+func parseYAML(file string, buf []byte) (*Document, error) {
+	var node yaml.Node
+
+	if err := yaml.Unmarshal(buf, &node); err != nil {
+		p := Problem{File: file, Message: err.Error()}
+		if m := syntaxErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = m[2]
+		}
+		return nil, p
+	}
+
+	d := &Document{File: file}
+
+	if len(node.Content) > 0 {
+		d.root = node.Content[0]
+	}
+
+	return d, nil
+}
+
+// lookup returns the node at the given route, or nil.
+func (d *Document) lookup(route ...string) *yaml.Node {
+	node := d.root
+	for _, key := range route {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		var next *yaml.Node
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == key {
+				next = node.Content[i+1]
+				break
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// Has returns true if there is a value at the given route.
+func (d *Document) Has(route ...string) bool {
+	return d.lookup(route...) != nil
+}
+
+// Line returns the line of the value at the given route, or 0 if there's no
+// such value.
+func (d *Document) Line(route ...string) int {
+	if node := d.lookup(route...); node != nil {
+		return node.Line
+	}
+	return 0
+}
+
+// Keys returns the keys of the map at the given route, along with their line
+// numbers.
+func (d *Document) Keys(route ...string) map[string]int {
+	node := d.lookup(route...)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return nil
+	}
+	keys := make(map[string]int)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		keys[node.Content[i].Value] = node.Content[i].Line
+	}
+	return keys
+}
+
+// Validate checks the document against a schema and returns all problems
+// found. Unknown keys are reported as warnings.
+func (d *Document) Validate(schema *Schema) Problems {
+	var problems Problems
+
+	if d.root != nil {
+		problems = d.validate(d.root, schema, "", problems)
+	}
+
+	sort.Stable(problems)
+
+	return problems
+}
+
+// matches returns true if the node has the kind the schema expects, without
+// looking into children.
+func matches(node *yaml.Node, schema *Schema) bool {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	switch schema.Kind {
+	case Any:
+		return true
+	case Map, Dict:
+		return node.Kind == yaml.MappingNode
+	case List:
+		return node.Kind == yaml.SequenceNode
+	case String:
+		return node.Kind == yaml.ScalarNode && node.Tag != "!!null"
+	case Int:
+		return node.Kind == yaml.ScalarNode && node.Tag == "!!int"
+	case Bool:
+		return node.Kind == yaml.ScalarNode && node.Tag == "!!bool"
+	}
+	return false
+}
+
+// describe returns a human description of what a schema expects.
+func describe(schema *Schema) string {
+	names := []string{schema.Kind.String()}
+	for _, alt := range schema.Or {
+		names = append(names, alt.Kind.String())
+	}
+	return strings.Join(names, " or ")
+}
+
+func (d *Document) validate(node *yaml.Node, schema *Schema, path string, problems Problems) Problems {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	if !matches(node, schema) {
+		for _, alt := range schema.Or {
+			if matches(node, alt) {
+				return d.validate(node, alt, path, problems)
+			}
+		}
+		return append(problems, Problem{
+			File:    d.File,
+			Line:    node.Line,
+			Message: fmt.Sprintf("%s: expecting %s.", name(path), describe(schema)),
+		})
+	}
+
+	switch schema.Kind {
+	case String:
+		if len(schema.Enum) > 0 {
+			for _, v := range schema.Enum {
+				if node.Value == v {
+					return problems
+				}
+			}
+			problems = append(problems, Problem{
+				File:    d.File,
+				Line:    node.Line,
+				Message: fmt.Sprintf("%s: unknown value %q, expecting one of %q.", name(path), node.Value, schema.Enum),
+			})
+		}
+	case Map:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			child, ok := schema.Keys[key.Value]
+			if !ok {
+				problems = append(problems, Problem{
+					File:    d.File,
+					Line:    key.Line,
+					Message: fmt.Sprintf("%s: unknown key %q.", name(path), key.Value),
+					Warning: true,
+				})
+				continue
+			}
+			problems = d.validate(value, child, join(path, key.Value), problems)
+		}
+	case Dict:
+		if schema.Elem != nil {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				problems = d.validate(node.Content[i+1], schema.Elem, join(path, node.Content[i].Value), problems)
+			}
+		}
+	case List:
+		if schema.Elem != nil {
+			for i, item := range node.Content {
+				problems = d.validate(item, schema.Elem, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	}
+
+	return problems
+}
+
+// join appends a key to a dotted path.
+func join(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// name returns a printable name for a path.
+func name(path string) string {
+	if path == "" {
+		return "document"
+	}
+	return path
+}
@@ -0,0 +1,273 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+var testSchema = &Schema{
+	Kind: Map,
+	Keys: map[string]*Schema{
+		"name": {Kind: String},
+		"port": {Kind: Int},
+		"type": {Kind: String, Enum: []string{"a", "b"}},
+		"list": {Kind: List, Elem: &Schema{Kind: String}},
+		"any":  {Kind: Any},
+	},
+}
+
+func TestValidate(t *testing.T) {
+	doc, err := Parse("test.yaml", []byte("name: foo\nport: bar\ntype: c\nlist:\n  - x\n  - { y: z }\nunknown: 1\nany: { a: 1 }\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	problems := doc.Validate(testSchema)
+
+	expected := []struct {
+		line    int
+		warning bool
+	}{
+		{2, false},
+		{3, false},
+		{6, false},
+		{7, true},
+	}
+
+	if len(problems) != len(expected) {
+		t.Fatalf("Expecting %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+
+	for i := range expected {
+		if problems[i].Line != expected[i].line || problems[i].Warning != expected[i].warning {
+			t.Fatalf("Unexpected problem %d: %v", i, problems[i])
+		}
+	}
+
+	if problems.Errors() != 3 {
+		t.Fatalf("Expecting 3 errors, got %d.", problems.Errors())
+	}
+
+	if doc.Line("list") != 5 || !doc.Has("any", "a") || doc.Has("any", "b") {
+		t.Fatal("Unexpected lookup result.")
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	_, err := Parse("test.yaml", []byte("a: b\n c: d\n"))
+	if err == nil {
+		t.Fatal("Expecting a syntax error.")
+	}
+	if p, ok := err.(Problem); !ok || p.Line != 2 {
+		t.Fatalf("Expecting a problem on line 2, got %v", err)
+	}
+}
+
+func TestProblemFor(t *testing.T) {
+	p := Problem{File: "settings.yaml", Line: 3, Message: "broken"}
+
+	if got := ProblemFor("other.yaml", p); got != p {
+		t.Fatalf("Expecting %v, got %v.", p, got)
+	}
+
+	if got := ProblemFor("other.yaml", fmt.Errorf("reading: %w", p)); got != p {
+		t.Fatalf("Expecting a wrapped problem to be found, got %v.", got)
+	}
+
+	expected := Problem{File: "other.yaml", Message: "permission denied"}
+	if got := ProblemFor("other.yaml", errors.New("permission denied")); got != expected {
+		t.Fatalf("Expecting %v, got %v.", expected, got)
+	}
+
+	if _, problems := LoadServerConfig("missing.yaml"); len(problems) != 1 || problems[0].File != "missing.yaml" {
+		t.Fatalf("Expecting a problem for the missing file, got %v.", problems)
+	}
+
+	if _, problems := LoadSiteConfig("missing.yaml"); len(problems) != 1 || problems[0].File != "missing.yaml" {
+		t.Fatalf("Expecting a problem for the missing file, got %v.", problems)
+	}
+}
+
+const testSettings = `server:
+  port: "9000"
+  shutdown_timeout: 5
+  pid_file: "/tmp/luminos.pid"
+  listeners:
+    - socket: "/tmp/luminos.sock"
+      type: "fastcgi"
+    - port: 9443
+      tls:
+        ciphers: [ "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256" ]
+        acme:
+          email: "admin@example.org"
+hosts:
+  default: "./sites/default"
+  example.org:
+    root: "./sites/example"
+    aliases: [ "www.example.org" ]
+`
+
+func TestServerConfig(t *testing.T) {
+	doc, err := Parse("settings.yaml", []byte(testSettings))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, problems := doc.ServerConfig()
+	if c == nil {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	if c.Server.Listener.Port != 9000 || c.Server.PidFile != "/tmp/luminos.pid" {
+		t.Fatalf("Unexpected server section: %#v", c.Server)
+	}
+
+	if c.Server.ShutdownTimeout != Duration(5*time.Second) {
+		t.Fatalf("Unexpected shutdown timeout: %v", c.Server.ShutdownTimeout)
+	}
+
+	listeners := c.ListenerList()
+	if len(listeners) != 2 || listeners[0].Type != "fastcgi" || listeners[1].Type != DefaultServerType {
+		t.Fatalf("Unexpected listeners: %#v", listeners)
+	}
+
+	tls := listeners[1].TLS
+	if listeners[1].Line != 8 || tls == nil || len(tls.Ciphers.Suites) != 1 || tls.ACME.Cache != DefaultACMECache {
+		t.Fatalf("Unexpected TLS listener: %#v", listeners[1])
+	}
+
+	if len(c.Hosts) != 2 || c.Hosts[0].Name != "default" || c.Hosts[1].Root != "./sites/example" || c.Hosts[1].Line != 15 {
+		t.Fatalf("Unexpected hosts: %#v", c.Hosts)
+	}
+
+	c.Hosts.Set("a.example.org", "./a")
+	if c.Hosts[0].Name != "a.example.org" {
+		t.Fatalf("Expecting hosts to be sorted: %#v", c.Hosts)
+	}
+}
+
+func TestServerConfigProblems(t *testing.T) {
+	doc, err := Parse("settings.yaml", []byte("server:\n  port: 80\n  shutdown_timeout: \"soon\"\n  tls:\n    redirect: 80\n  typo: 1\nhosts:\n  default: \"\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, problems := doc.ServerConfig()
+	if c != nil {
+		t.Fatal("Expecting no config.")
+	}
+
+	if problems.Errors() != 1 || problems[0].Line != 3 || problems[1].Line != 6 || !problems[1].Warning {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	doc, _ = Parse("settings.yaml", []byte("server:\n  port: 80\n  tls:\n    redirect: 80\nhosts:\n  default: \"\"\n"))
+
+	if _, problems = doc.ServerConfig(); problems.Errors() != 2 || problems[0].Line != 2 || problems[1].Line != 6 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+}
+
+func TestSiteConfig(t *testing.T) {
+	doc, err := Parse("site.yaml", []byte("content:\n  webroot: \"public\"\npage:\n  brand: \"Luminos\"\nextra: 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, problems := doc.SiteConfig()
+	if c == nil || len(problems) != 0 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	if c.Content.Webroot != "public" || c.Content.Templates != DefaultTemplates || c.Content.Markdown != "" {
+		t.Fatalf("Unexpected content section: %#v", c.Content)
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	doc, err := Parse("site.yaml", []byte("cache_control:\n  - path: \"/blog/*\"\n    value: \"public, max-age=60\"\n  - path: \"*.css\"\n    value: \"max-age=3600\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, problems := doc.SiteConfig()
+	if c == nil || len(problems) != 0 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	tests := map[string]string{
+		"/blog/post":        "public, max-age=60",
+		"/blog/post/deeper": "",
+		"/css/site.css":     "max-age=3600",
+		"/about":            "",
+	}
+
+	for p, expected := range tests {
+		if value := c.CacheControlFor(p); value != expected {
+			t.Errorf("%s: expecting %q, got %q.", p, expected, value)
+		}
+	}
+
+	doc, _ = Parse("site.yaml", []byte("cache_control:\n  - path: \"[\"\n    value: \"no-cache\"\n"))
+	if _, problems = doc.SiteConfig(); problems.Errors() != 1 || problems[0].Line != 2 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+}
+
+func TestProxyConfig(t *testing.T) {
+	doc, err := Parse("site.yaml", []byte("proxy:\n  - path: \"/api/\"\n    url: \"http://127.0.0.1:8080/\"\n  - path: \"/play\"\n    url: \"http://127.0.0.1:8081\"\n    timeout: 5\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, problems := doc.SiteConfig()
+	if c == nil || len(problems) != 0 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+
+	if len(c.Proxy) != 2 || c.Proxy[0].Timeout != Duration(DefaultProxyTimeout) || c.Proxy[1].Timeout != Duration(5*time.Second) || c.Proxy[1].Line != 4 {
+		t.Fatalf("Unexpected proxies: %#v", c.Proxy)
+	}
+
+	doc, _ = Parse("site.yaml", []byte("proxy:\n  - path: \"api\"\n    url: \"127.0.0.1:8080\"\n"))
+	if _, problems = doc.SiteConfig(); problems.Errors() != 2 || problems[0].Line != 2 {
+		t.Fatalf("Unexpected problems: %v", problems)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	files := map[string]string{
+		"settings.json": "{\n\t\"server\": {\"port\": 9000},\n\t\"hosts\": {\"default\": \"./sites/default\"}\n}\n",
+		"settings.toml": "[server]\nport = 9000\n\n[hosts]\ndefault = \"./sites/default\"\n",
+	}
+
+	for file, data := range files {
+		doc, err := Parse(file, []byte(data))
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+
+		c, problems := doc.ServerConfig()
+		if c == nil || len(problems) != 0 {
+			t.Fatalf("%s: unexpected problems: %v", file, problems)
+		}
+
+		if c.Server.Listener.Port != 9000 || len(c.Hosts) != 1 || c.Hosts[0].Root != "./sites/default" {
+			t.Fatalf("%s: unexpected config: %#v", file, c)
+		}
+
+		if _, ok := doc.Values()["server"].(map[interface{}]interface{}); !ok {
+			t.Fatalf("%s: unexpected values: %#v", file, doc.Values())
+		}
+	}
+
+	if _, err := Parse("settings.json", []byte("{\n\"a\": 1,\n}\n")); err == nil || err.(Problem).Line != 3 {
+		t.Fatalf("Expecting a syntax error on line 3, got %v", err)
+	}
+
+	if _, err := Parse("settings.toml", []byte("a = 1\nb = \n")); err == nil || err.(Problem).Line != 2 {
+		t.Fatalf("Expecting a syntax error on line 2, got %v", err)
+	}
+}
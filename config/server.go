@@ -0,0 +1,623 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default values for the settings.yaml file.
+const (
+	DefaultServerType      = "standalone"
+	DefaultShutdownTimeout = time.Second * 30
+	DefaultACMECache       = "./certs"
+	DefaultLivePath        = "/healthz"
+	DefaultReadyPath       = "/readyz"
+	DefaultCompressMinSize = 1024
+)
+
+// Matches line numbers on decoding errors.
+var lineErrorPattern = regexp.MustCompile(`^line (\d+): (.*)$`)
+
+// tlsSchema describes the "tls" section of a listener.
+var tlsSchema = &Schema{
+	Kind: Map,
+	Keys: map[string]*Schema{
+		"cert":        {Kind: String},
+		"key":         {Kind: String},
+		"min_version": {Kind: String, Enum: []string{"1.0", "1.1", "1.2", "1.3"}},
+		"ciphers": {
+			Kind: String,
+			Or:   []*Schema{{Kind: List, Elem: &Schema{Kind: String}}},
+		},
+		"redirect": {Kind: Int},
+		"acme": {
+			Kind: Map,
+			Keys: map[string]*Schema{
+				"email":     {Kind: String},
+				"cache":     {Kind: String},
+				"directory": {Kind: String},
+				"ca":        {Kind: String},
+			},
+		},
+		"hosts": {
+			Kind: Dict,
+			Elem: &Schema{
+				Kind: Map,
+				Keys: map[string]*Schema{
+					"cert": {Kind: String},
+					"key":  {Kind: String},
+				},
+			},
+		},
+	},
+}
+
+// listenerKeys returns the keys that are accepted by listener entries.
+func listenerKeys() map[string]*Schema {
+	return map[string]*Schema{
+		"type":   {Kind: String, Enum: []string{"fastcgi", "standalone"}},
+		"bind":   {Kind: String},
+		"port":   {Kind: Int, Or: []*Schema{{Kind: String}}},
+		"socket": {Kind: String},
+		"name":   {Kind: String},
+		"tls":    tlsSchema,
+	}
+}
+
+// SettingsSchema describes the settings.yaml file.
+var SettingsSchema = func() *Schema {
+	server := &Schema{Kind: Map, Keys: listenerKeys()}
+
+	server.Keys["shutdown_timeout"] = &Schema{Kind: String, Or: []*Schema{{Kind: Int}}}
+	server.Keys["pid_file"] = &Schema{Kind: String}
+	server.Keys["listeners"] = &Schema{
+		Kind: List,
+		Elem: &Schema{Kind: Map, Keys: listenerKeys()},
+	}
+	server.Keys["access_log"] = &Schema{
+		Kind: Map,
+		Keys: map[string]*Schema{
+			"format": {Kind: String, Enum: []string{"common", "combined", "json"}},
+			"file":   {Kind: String},
+		},
+	}
+	server.Keys["log"] = &Schema{
+		Kind: Map,
+		Keys: map[string]*Schema{
+			"level":  {Kind: String, Enum: []string{"debug", "info", "warn", "error"}},
+			"format": {Kind: String, Enum: []string{"text", "json"}},
+			"file":   {Kind: String},
+		},
+	}
+	server.Keys["cache"] = &Schema{
+		Kind: Map,
+		Keys: map[string]*Schema{
+			"entries": {Kind: Int},
+		},
+	}
+	server.Keys["compression"] = &Schema{
+		Kind: Map,
+		Keys: map[string]*Schema{
+			"min_size": {Kind: Int},
+			"types":    {Kind: List, Elem: &Schema{Kind: String}},
+		},
+	}
+	server.Keys["health"] = &Schema{
+		Kind: Map,
+		Keys: map[string]*Schema{
+			"live":  {Kind: String},
+			"ready": {Kind: String},
+		},
+	}
+	server.Keys["admin"] = &Schema{
+		Kind: Map,
+		Keys: map[string]*Schema{
+			"bind":   {Kind: String},
+			"port":   {Kind: Int, Or: []*Schema{{Kind: String}}},
+			"socket": {Kind: String},
+			"name":   {Kind: String},
+		},
+	}
+
+	return &Schema{
+		Kind: Map,
+		Keys: map[string]*Schema{
+			"server": server,
+			"hosts": {
+				Kind: Dict,
+				Elem: &Schema{
+					Kind: String,
+					Or: []*Schema{{
+						Kind: Map,
+						Keys: map[string]*Schema{
+							"root":       {Kind: String},
+							"aliases":    {Kind: List, Elem: &Schema{Kind: String}},
+							"access_log": {Kind: String},
+						},
+					}},
+				},
+			},
+		},
+	}
+}()
+
+// typeError returns a decoding error for a node, decoding goes on after it so
+// all errors are reported.
+func typeError(node *yaml.Node, format string, args ...interface{}) error {
+	return &yaml.TypeError{Errors: []string{fmt.Sprintf("line %d: ", node.Line) + fmt.Sprintf(format, args...)}}
+}
+
+// Duration is a duration given as a string ("10s", "1m") or as a number of
+// seconds.
+type Duration time.Duration
+
+// ParseDuration reads a duration string or a number of seconds.
+func ParseDuration(s string) (Duration, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return Duration(time.Duration(n) * time.Second), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid duration %q.", s)
+	}
+	return Duration(d), nil
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	v, err := ParseDuration(node.Value)
+	if err != nil {
+		return typeError(node, "invalid duration %q", node.Value)
+	}
+	*d = v
+	return nil
+}
+
+// Port is a port number, given as a number or a string.
+type Port int
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *Port) UnmarshalYAML(node *yaml.Node) error {
+	n, err := strconv.Atoi(node.Value)
+	if err != nil || n < 0 || n > 65535 {
+		return typeError(node, "invalid port %q", node.Value)
+	}
+	*p = Port(n)
+	return nil
+}
+
+// Ciphers is a cipher policy name or a list of cipher suite names.
+type Ciphers struct {
+	// Policy name, "default" or "modern".
+	Policy string
+	// Names of cipher suites, if no policy was given.
+	Suites []string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *Ciphers) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.SequenceNode {
+		return node.Decode(&c.Suites)
+	}
+	c.Policy = node.Value
+	return nil
+}
+
+// CertificateConfig is a certificate and its key.
+type CertificateConfig struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+}
+
+// ACMEConfig is the "acme" section of a TLS configuration.
+type ACMEConfig struct {
+	Email string `yaml:"email"`
+	// Directory where certificates are kept.
+	Cache string `yaml:"cache"`
+	// Directory URL of the ACME server, empty for the default CA.
+	Directory string `yaml:"directory"`
+	// Additional CA to trust when talking to the ACME server.
+	CA string `yaml:"ca"`
+}
+
+// TLSConfig is the "tls" section of a listener.
+type TLSConfig struct {
+	// Certificate used when no host certificate matches.
+	CertificateConfig `yaml:",inline"`
+	MinVersion        string  `yaml:"min_version"`
+	Ciphers           Ciphers `yaml:"ciphers"`
+	// Plain HTTP port that redirects clients to HTTPS, 0 if disabled.
+	Redirect int `yaml:"redirect"`
+	// ACME settings, nil if disabled.
+	ACME *ACMEConfig `yaml:"acme"`
+	// Certificates by host name.
+	Hosts map[string]CertificateConfig `yaml:"hosts"`
+}
+
+// ListenerConfig describes a network listener.
+type ListenerConfig struct {
+	// Name of the inherited socket to use, if any.
+	Name string `yaml:"name"`
+	// "standalone" or "fastcgi".
+	Type string `yaml:"type"`
+	Bind string `yaml:"bind"`
+	Port Port   `yaml:"port"`
+	// Unix socket, used instead of Bind and Port if given.
+	Socket string `yaml:"socket"`
+	// TLS settings, nil if disabled.
+	TLS *TLSConfig `yaml:"tls"`
+	// Line of the listener on the settings file.
+	Line int `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *ListenerConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain ListenerConfig
+	v := plain{Type: DefaultServerType, Line: node.Line}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*l = ListenerConfig(v)
+	return nil
+}
+
+// AdminConfig describes the admin listener, a plain HTTP server that exposes
+// metrics.
+type AdminConfig struct {
+	// Name of the inherited socket to use, if any.
+	Name string `yaml:"name"`
+	Bind string `yaml:"bind"`
+	Port Port   `yaml:"port"`
+	// Unix socket, used instead of Bind and Port if given.
+	Socket string `yaml:"socket"`
+	// Line of the section on the settings file.
+	Line int `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (a *AdminConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain AdminConfig
+	v := plain{Line: node.Line}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*a = AdminConfig(v)
+	return nil
+}
+
+// AccessLogConfig is the "access_log" section of the server settings.
+type AccessLogConfig struct {
+	// "common", "combined" or "json".
+	Format string `yaml:"format"`
+	// File that requests are logged to, the standard output if empty. Hosts
+	// may log to their own files.
+	File string `yaml:"file"`
+}
+
+// LogConfig is the "log" section of the server settings, for application
+// messages.
+type LogConfig struct {
+	// "debug", "info", "warn" or "error".
+	Level string `yaml:"level"`
+	// "text" or "json".
+	Format string `yaml:"format"`
+	// File that messages are written to, the standard error if empty.
+	File string `yaml:"file"`
+}
+
+// CacheConfig is the "cache" section of the server settings.
+type CacheConfig struct {
+	// Maximum number of rendered pages kept in memory, 0 disables the cache.
+	Entries int `yaml:"entries"`
+}
+
+// CompressionConfig is the "compression" section of the server settings, it
+// enables gzip and deflate responses.
+type CompressionConfig struct {
+	// Responses smaller than this number of bytes are not compressed.
+	MinSize int `yaml:"min_size"`
+	// Media types that are compressed, the defaults are used if empty.
+	Types []string `yaml:"types"`
+	// Line of the section on the settings file.
+	Line int `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *CompressionConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain CompressionConfig
+	v := plain{MinSize: DefaultCompressMinSize, Line: node.Line}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*c = CompressionConfig(v)
+	return nil
+}
+
+// HealthConfig is the "health" section of the server settings, it enables the
+// health and readiness endpoints.
+type HealthConfig struct {
+	// Path that answers while the process is alive.
+	Live string `yaml:"live"`
+	// Path that answers with success when every host is ready.
+	Ready string `yaml:"ready"`
+	// Line of the section on the settings file.
+	Line int `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (h *HealthConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain HealthConfig
+	v := plain{Live: DefaultLivePath, Ready: DefaultReadyPath, Line: node.Line}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*h = HealthConfig(v)
+	return nil
+}
+
+// ServerSection is the "server" section of the settings file.
+type ServerSection struct {
+	// The section itself describes a listener when Listeners is empty.
+	Listener        ListenerConfig   `yaml:",inline"`
+	Listeners       []ListenerConfig `yaml:"listeners"`
+	PidFile         string           `yaml:"pid_file"`
+	ShutdownTimeout Duration         `yaml:"shutdown_timeout"`
+	// Admin listener, nil if disabled.
+	Admin *AdminConfig `yaml:"admin"`
+	// Health endpoints, nil if disabled.
+	Health *HealthConfig `yaml:"health"`
+	// Access log settings.
+	AccessLog AccessLogConfig `yaml:"access_log"`
+	// Application log settings.
+	Log LogConfig `yaml:"log"`
+	// Rendered pages cache.
+	Cache CacheConfig `yaml:"cache"`
+	// Response compression, nil if disabled.
+	Compression *CompressionConfig `yaml:"compression"`
+}
+
+// HostConfig is an entry of the hosts map.
+type HostConfig struct {
+	// Route, like "example.org" or "example.org/docs".
+	Name    string   `yaml:"-"`
+	Root    string   `yaml:"root"`
+	Aliases []string `yaml:"aliases"`
+	// File that requests for this host are logged to, if not the one of the
+	// server.
+	AccessLog string `yaml:"access_log"`
+	// Line of the entry on the settings file.
+	Line int `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler, entries are either a path or a
+// map with "root" and "aliases".
+func (h *HostConfig) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		h.Root = node.Value
+		return nil
+	}
+	type plain HostConfig
+	return node.Decode((*plain)(h))
+}
+
+// HostList is the hosts map, sorted by name.
+type HostList []HostConfig
+
+func (l HostList) Len() int {
+	return len(l)
+}
+
+func (l HostList) Less(i, j int) bool {
+	return l[i].Name < l[j].Name
+}
+
+func (l HostList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (l *HostList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return typeError(node, "expecting a map of hosts")
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		h := HostConfig{}
+		if err := node.Content[i+1].Decode(&h); err != nil {
+			return err
+		}
+		h.Name, h.Line = node.Content[i].Value, node.Content[i].Line
+		*l = append(*l, h)
+	}
+	sort.Sort(*l)
+	return nil
+}
+
+// Set points the named host to a directory, the host is added if it does not
+// exist.
+func (l *HostList) Set(name string, root string) {
+	for i := range *l {
+		if (*l)[i].Name == name {
+			(*l)[i].Root = root
+			return
+		}
+	}
+	*l = append(*l, HostConfig{Name: name, Root: root})
+	sort.Sort(*l)
+}
+
+// ServerConfig is the settings.yaml file.
+type ServerConfig struct {
+	// File name, empty if settings were not read from a file.
+	File   string        `yaml:"-"`
+	Server ServerSection `yaml:"server"`
+	Hosts  HostList      `yaml:"hosts"`
+}
+
+// NewServerConfig returns settings with default values and no hosts.
+func NewServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Server: ServerSection{
+			Listener:        ListenerConfig{Type: DefaultServerType},
+			ShutdownTimeout: Duration(DefaultShutdownTimeout),
+		},
+	}
+}
+
+// ListenerList returns the listeners of the server.listeners list or, if
+// there is no such list, the server section as the only listener.
+func (c *ServerConfig) ListenerList() []ListenerConfig {
+	if len(c.Server.Listeners) > 0 {
+		return c.Server.Listeners
+	}
+	return []ListenerConfig{c.Server.Listener}
+}
+
+// decodeProblems converts decoding errors into problems.
+func (d *Document) decodeProblems(err error) Problems {
+	var problems Problems
+
+	messages := []string{err.Error()}
+	if e, ok := err.(*yaml.TypeError); ok {
+		messages = e.Errors
+	}
+
+	for _, message := range messages {
+		p := Problem{File: d.File, Message: message}
+		if m := lineErrorPattern.FindStringSubmatch(message); m != nil {
+			p.Line, _ = strconv.Atoi(m[1])
+			p.Message = m[2]
+		}
+		problems = append(problems, p)
+	}
+
+	return problems
+}
+
+// ServerConfig validates the document as a settings.yaml file and returns its
+// values. The returned config is nil if there are errors.
+func (d *Document) ServerConfig() (*ServerConfig, Problems) {
+	problems := d.Validate(SettingsSchema)
+
+	if !d.Has("server") {
+		problems = append(problems, Problem{File: d.File, Message: "Missing \"server\" entry."})
+	}
+
+	if !d.Has("hosts") {
+		problems = append(problems, Problem{File: d.File, Message: "Missing \"hosts\" entry."})
+	}
+
+	if problems.Errors() > 0 {
+		return nil, problems
+	}
+
+	c := NewServerConfig()
+	c.File = d.File
+	c.Server.Listener.Line = d.Line("server")
+
+	if err := d.root.Decode(c); err != nil {
+		problems = append(problems, d.decodeProblems(err)...)
+		sort.Stable(problems)
+		return nil, problems
+	}
+
+	problems = append(problems, c.validate()...)
+	sort.Stable(problems)
+
+	if problems.Errors() > 0 {
+		return nil, problems
+	}
+
+	return c, problems
+}
+
+// validate looks for values that have the expected type but can't be used.
+func (c *ServerConfig) validate() Problems {
+	var problems Problems
+
+	for _, h := range c.Hosts {
+		if strings.TrimSpace(h.Root) == "" {
+			problems = append(problems, Problem{File: c.File, Line: h.Line, Message: fmt.Sprintf("Host %s does not point to a directory.", h.Name)})
+		}
+	}
+
+	for _, l := range c.ListenerList() {
+		if l.TLS == nil {
+			continue
+		}
+		if l.Type != "standalone" {
+			problems = append(problems, Problem{File: c.File, Line: l.Line, Message: "TLS is only available for the standalone server."})
+		}
+		if l.TLS.Cert == "" && l.TLS.Key == "" && len(l.TLS.Hosts) == 0 && l.TLS.ACME == nil {
+			problems = append(problems, Problem{File: c.File, Line: l.Line, Message: "TLS was enabled but no certificates were given."})
+		}
+		if (l.TLS.Cert == "") != (l.TLS.Key == "") {
+			problems = append(problems, Problem{File: c.File, Line: l.Line, Message: "Expecting both \"cert\" and \"key\" entries."})
+		}
+		if l.TLS.ACME != nil && l.TLS.ACME.Cache == "" {
+			l.TLS.ACME.Cache = DefaultACMECache
+		}
+	}
+
+	if a := c.Server.Admin; a != nil && a.Socket == "" && a.Port == 0 {
+		problems = append(problems, Problem{File: c.File, Line: a.Line, Message: "The admin listener needs a port or a socket."})
+	}
+
+	if h := c.Server.Health; h != nil {
+		for _, p := range []string{h.Live, h.Ready} {
+			if !strings.HasPrefix(p, "/") {
+				problems = append(problems, Problem{File: c.File, Line: h.Line, Message: fmt.Sprintf("Health path %q must begin with \"/\".", p)})
+			}
+		}
+	}
+
+	if z := c.Server.Compression; z != nil && z.MinSize < 0 {
+		problems = append(problems, Problem{File: c.File, Line: z.Line, Message: "The minimum size for compression can't be negative."})
+	}
+
+	if c.Server.Cache.Entries < 0 {
+		problems = append(problems, Problem{File: c.File, Line: c.Server.Listener.Line, Message: "The number of cache entries can't be negative."})
+	}
+
+	if c.Server.ShutdownTimeout < 0 {
+		problems = append(problems, Problem{File: c.File, Line: c.Server.Listener.Line, Message: "The shutdown timeout can't be negative."})
+	}
+
+	return problems
+}
+
+// LoadServerConfig reads and validates a settings.yaml file. The returned
+// config is nil if there are errors.
+func LoadServerConfig(file string) (*ServerConfig, Problems) {
+	d, err := Open(file)
+	if err != nil {
+		return nil, Problems{ProblemFor(file, err)}
+	}
+	return d.ServerConfig()
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SiteSchema describes the site.yaml file. Sections other than "content",
+// "cache_control" and "proxy" are free-form and read by templates through the
+// setting and settings functions.
+var SiteSchema = &Schema{
+	Kind: Map,
+	Keys: map[string]*Schema{
+		"content": {
+			Kind: Map,
+			Keys: map[string]*Schema{
+				"markdown":  {Kind: String},
+				"webroot":   {Kind: String},
+				"templates": {Kind: String},
+			},
+		},
+		"cache_control": {
+			Kind: List,
+			Elem: &Schema{
+				Kind: Map,
+				Keys: map[string]*Schema{
+					"path":  {Kind: String},
+					"value": {Kind: String},
+				},
+			},
+		},
+		"proxy": {
+			Kind: List,
+			Elem: &Schema{
+				Kind: Map,
+				Keys: map[string]*Schema{
+					"path":    {Kind: String},
+					"url":     {Kind: String},
+					"timeout": {Kind: String, Or: []*Schema{{Kind: Int}}},
+				},
+			},
+		},
+		"page": {Kind: Any},
+	},
+}
+
+// Default values for the site.yaml file.
+const (
+	DefaultTemplates    = "templates"
+	DefaultProxyTimeout = time.Second * 30
+)
+
+// ContentConfig is the "content" section of the site.yaml file.
+type ContentConfig struct {
+	// Directory of pages, empty to look for "content" or "markdown".
+	Markdown string `yaml:"markdown"`
+	// Directory of static files, empty for "webroot".
+	Webroot string `yaml:"webroot"`
+	// Directory of templates.
+	Templates string `yaml:"templates"`
+}
+
+// CacheControlRule sets the Cache-Control header of the paths that match a
+// glob.
+type CacheControlRule struct {
+	// Glob, like "/blog/*". Globs without a slash match file names, like
+	// "*.css".
+	Path string `yaml:"path"`
+	// Value of the Cache-Control header, like "public, max-age=3600".
+	Value string `yaml:"value"`
+	// Line of the rule on the settings file.
+	Line int `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (r *CacheControlRule) UnmarshalYAML(node *yaml.Node) error {
+	type plain CacheControlRule
+	v := plain{Line: node.Line}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*r = CacheControlRule(v)
+	return nil
+}
+
+// Match returns true if the rule applies to the given path.
+func (r *CacheControlRule) Match(p string) bool {
+	if !strings.Contains(r.Path, "/") {
+		p = path.Base(p)
+	}
+	ok, _ := path.Match(r.Path, p)
+	return ok
+}
+
+// ProxyConfig mounts another service on a path of the site.
+type ProxyConfig struct {
+	// Path prefix, like "/api/". Requests below it are forwarded.
+	Path string `yaml:"path"`
+	// Backend URL, like "http://127.0.0.1:8080/". The path prefix is replaced
+	// by the path of the URL.
+	URL string `yaml:"url"`
+	// Maximum time to wait for the backend to answer, 0 waits forever.
+	Timeout Duration `yaml:"timeout"`
+	// Line of the entry on the settings file.
+	Line int `yaml:"-"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (p *ProxyConfig) UnmarshalYAML(node *yaml.Node) error {
+	type plain ProxyConfig
+	v := plain{Timeout: Duration(DefaultProxyTimeout), Line: node.Line}
+	if err := node.Decode(&v); err != nil {
+		return err
+	}
+	*p = ProxyConfig(v)
+	return nil
+}
+
+// SiteConfig is the site.yaml file. Templates read the raw document instead.
+type SiteConfig struct {
+	// File name, empty if the site has no settings file.
+	File    string        `yaml:"-"`
+	Content ContentConfig `yaml:"content"`
+	// Cache-Control rules, the first one that matches a path is used.
+	CacheControl []CacheControlRule `yaml:"cache_control"`
+	// Services mounted on the site.
+	Proxy []ProxyConfig `yaml:"proxy"`
+}
+
+// CacheControlFor returns the Cache-Control header for the given path, or an
+// empty string if no rule matches.
+func (c *SiteConfig) CacheControlFor(p string) string {
+	for i := range c.CacheControl {
+		if c.CacheControl[i].Match(p) {
+			return c.CacheControl[i].Value
+		}
+	}
+	return ""
+}
+
+// NewSiteConfig returns site settings with default values.
+func NewSiteConfig() *SiteConfig {
+	return &SiteConfig{
+		Content: ContentConfig{
+			Templates: DefaultTemplates,
+		},
+	}
+}
+
+// SiteConfig validates the document as a site.yaml file and returns its
+// values. Unknown sections are allowed, since templates may read them. The
+// returned config is nil if there are errors.
+func (d *Document) SiteConfig() (*SiteConfig, Problems) {
+	schema := &Schema{Kind: Map, Keys: map[string]*Schema{}}
+	for key, value := range SiteSchema.Keys {
+		schema.Keys[key] = value
+	}
+	for key := range d.Keys() {
+		if _, ok := schema.Keys[key]; !ok {
+			schema.Keys[key] = &Schema{Kind: Any}
+		}
+	}
+
+	problems := d.Validate(schema)
+
+	if problems.Errors() > 0 {
+		return nil, problems
+	}
+
+	c := NewSiteConfig()
+	c.File = d.File
+
+	if d.root != nil {
+		if err := d.root.Decode(c); err != nil {
+			return nil, append(problems, d.decodeProblems(err)...)
+		}
+	}
+
+	if c.Content.Templates == "" {
+		c.Content.Templates = DefaultTemplates
+	}
+
+	for _, r := range c.CacheControl {
+		if _, err := path.Match(r.Path, ""); err != nil || r.Path == "" {
+			problems = append(problems, Problem{File: c.File, Line: r.Line, Message: fmt.Sprintf("Invalid cache control path %q.", r.Path)})
+		}
+	}
+
+	for _, p := range c.Proxy {
+		if !strings.HasPrefix(p.Path, "/") {
+			problems = append(problems, Problem{File: c.File, Line: p.Line, Message: fmt.Sprintf("Proxy path %q must begin with \"/\".", p.Path)})
+		}
+		if u, err := url.Parse(p.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, Problem{File: c.File, Line: p.Line, Message: fmt.Sprintf("Invalid proxy URL %q.", p.URL)})
+		}
+		if p.Timeout < 0 {
+			problems = append(problems, Problem{File: c.File, Line: p.Line, Message: "The proxy timeout can't be negative."})
+		}
+	}
+
+	if problems.Errors() > 0 {
+		return nil, problems
+	}
+
+	return c, problems
+}
+
+// LoadSiteConfig reads and validates a site.yaml file. The returned config is
+// nil if there are errors.
+func LoadSiteConfig(file string) (*SiteConfig, Problems) {
+	d, err := Open(file)
+	if err != nil {
+		return nil, Problems{ProblemFor(file, err)}
+	}
+	return d.SiteConfig()
+}
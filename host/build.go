@@ -0,0 +1,459 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"menteslibres.net/luminos/cache"
+)
+
+// Host name used for pages rendered by Build when the host does not have one.
+const buildHostName = "localhost"
+
+// Name of the file, inside the output directory, that lists the files each
+// page included. Incremental builds use it to find pages that depend on
+// changed includes.
+const buildManifestName = ".luminos-build.json"
+
+// Matches href and src attributes.
+var linkAttributePattern = regexp.MustCompile(`(href|src)="([^"]*)"`)
+
+// BuildStats holds the results of a build.
+type BuildStats struct {
+	// Number of pages that were rendered.
+	Rendered int
+	// Number of pages that were up to date.
+	Skipped int
+	// Number of webroot files that were copied.
+	Copied int
+}
+
+// buildPage is a page that is going to be written by Build.
+type buildPage struct {
+	// Absolute URL of the page, relative to the host's path. Directories end
+	// with "/".
+	URL string
+	// Content file or directory.
+	File string
+	// Output file.
+	Output string
+}
+
+// isHidden returns true for files that are not listed nor served as pages
+// (partials like _header, and dot files).
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_")
+}
+
+// isPageFile returns true if the file has one of the expected extensions.
+func isPageFile(name string) bool {
+	for _, extension := range extensions {
+		if strings.HasSuffix(name, extension) {
+			return true
+		}
+	}
+	return false
+}
+
+// pageURL returns the URL of a content file, the same way menus link to it.
+func pageURL(rel string) string {
+	name := path.Base(rel)
+	for _, extension := range []string{".md", ".html"} {
+		if strings.HasSuffix(name, extension) {
+			return strings.TrimSuffix(rel, extension)
+		}
+	}
+	return rel
+}
+
+// buildName returns the host name that is given to the url template function
+// while building.
+func (host *Host) buildName() string {
+	name := host.Name
+	if i := strings.IndexAny(name, "/:"); i > -1 {
+		name = name[:i]
+	}
+	if name == "" || name == "default" || strings.Contains(name, "*") {
+		return buildHostName
+	}
+	return name
+}
+
+// buildPages walks the content directory and returns all the pages of the
+// host.
+func (host *Host) buildPages(docroot string, outdir string) ([]*buildPage, error) {
+	var pages []*buildPage
+
+	err := filepath.Walk(docroot, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel := filepath.ToSlash(strings.TrimPrefix(file, docroot))
+
+		if isHidden(info.Name()) && file != docroot {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		p := &buildPage{File: file}
+
+		if info.IsDir() {
+			p.URL = strings.TrimRight(rel, "/") + "/"
+		} else {
+			if !isPageFile(info.Name()) {
+				return nil
+			}
+			if p.URL = pageURL(rel); path.Base(p.URL) == "index" {
+				// Index files are rendered as their directories.
+				return nil
+			}
+		}
+
+		p.Output = filepath.Join(outdir, filepath.FromSlash(p.URL), "index.html")
+
+		pages = append(pages, p)
+
+		return nil
+	})
+
+	return pages, err
+}
+
+// newestModTime returns the most recent modification time of the given files.
+// Files that do not exist are ignored.
+func newestModTime(files ...string) time.Time {
+	var newest time.Time
+	for _, file := range files {
+		if stat, err := os.Stat(file); err == nil && stat.ModTime().After(newest) {
+			newest = stat.ModTime()
+		}
+	}
+	return newest
+}
+
+// dependencies returns the files and directories a page depends on: the
+// content file, header and footer, and the directories scanned for menus.
+func dependencies(localFile string, fileDir string) []string {
+	deps := []string{localFile, fileDir, fileDir + ".."}
+
+	if hfile, hstat := guessFile(fileDir+"_header", true); hstat != nil {
+		deps = append(deps, hfile)
+	}
+
+	if ffile, fstat := guessFile(fileDir+"_footer", true); fstat != nil {
+		deps = append(deps, ffile)
+	}
+
+	if dir, err := os.Open(fileDir); err == nil {
+		if files, err := dir.Readdir(-1); err == nil {
+			for _, f := range files {
+				if f.IsDir() {
+					deps = append(deps, fileDir+f.Name())
+				}
+			}
+		}
+		dir.Close()
+	}
+
+	return deps
+}
+
+// buildManifest maps the URL of every page that was built to the files its
+// templates included.
+type buildManifest map[string][]string
+
+// readBuildManifest reads the manifest of a previous build.
+func readBuildManifest(outdir string) (buildManifest, error) {
+	buf, err := ioutil.ReadFile(filepath.Join(outdir, buildManifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	m := buildManifest{}
+	if err = json.Unmarshal(buf, &m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// write saves the manifest into outdir.
+func (m buildManifest) write(outdir string) error {
+	buf, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(outdir, buildManifestName), buf, 0644)
+}
+
+// relativeLink rewrites a link found on the page at pageURL so it works
+// without a server: local links become relative and links to pages point to
+// their index.html file.
+func (host *Host) relativeLink(pageURL string, link string, pages map[string]bool) string {
+	base := &url.URL{Path: host.Path + pageURL}
+
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Opaque != "" {
+		return link
+	}
+
+	if u.Host != "" {
+		if u.Host != host.buildName() {
+			return link
+		}
+		u.Host = ""
+	}
+
+	if u.Path == "" {
+		// Only a query or a fragment.
+		return link
+	}
+
+	target := base.ResolveReference(u)
+
+	p := target.Path
+	if host.Path != "" {
+		if p != host.Path && !strings.HasPrefix(p, host.Path+"/") {
+			return link
+		}
+		p = strings.TrimPrefix(p, host.Path)
+	}
+
+	if p == "" {
+		p = "/"
+	}
+
+	file := strings.TrimPrefix(p, "/")
+
+	dir := strings.TrimRight(p, "/") + "/"
+	if pages[dir] || pages[p] {
+		file = path.Join(file, "index.html")
+	}
+
+	// Pages are written to pageURL/index.html.
+	from := strings.Trim(pageURL, "/")
+	if from == "" {
+		from = "."
+	}
+
+	rel, err := filepath.Rel(filepath.FromSlash(from), filepath.FromSlash(file))
+	if err != nil {
+		return link
+	}
+
+	target.Path = filepath.ToSlash(rel)
+	target.Host = ""
+	target.Scheme = ""
+
+	return strings.TrimPrefix(target.String(), "//")
+}
+
+// rewriteLinks makes all local links of a rendered page relative.
+func (host *Host) rewriteLinks(pageURL string, buf []byte, pages map[string]bool) []byte {
+	return linkAttributePattern.ReplaceAllFunc(buf, func(match []byte) []byte {
+		m := linkAttributePattern.FindSubmatch(match)
+		link := host.relativeLink(pageURL, string(m[2]), pages)
+		return []byte(fmt.Sprintf(`%s="%s"`, m[1], link))
+	})
+}
+
+// copyFile copies src into dst, creating parent directories.
+func copyFile(src string, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// isUpToDate returns true if dst exists and it's not older than since.
+func isUpToDate(dst string, since time.Time) bool {
+	stat, err := os.Stat(dst)
+	return err == nil && !stat.ModTime().Before(since)
+}
+
+// copyWebroot copies the webroot directory into outdir.
+func (host *Host) copyWebroot(outdir string, incremental bool, stats *BuildStats) error {
+	webroot := filepath.Clean(host.webroot())
+
+	if _, err := os.Stat(webroot); err != nil {
+		return nil
+	}
+
+	return filepath.Walk(webroot, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		dst := filepath.Join(outdir, strings.TrimPrefix(file, webroot))
+
+		if incremental && isUpToDate(dst, info.ModTime()) {
+			return nil
+		}
+
+		stats.Copied++
+
+		return copyFile(file, dst)
+	})
+}
+
+// Build renders every page of the host into outdir, as path/index.html files,
+// and copies the webroot. Local links are rewritten so the output can be
+// browsed without a server. If incremental is true, pages that are newer than
+// their sources, included files, templates and settings are not rendered
+// again. Included files are only known after rendering, they are recorded in
+// a manifest inside outdir; every page is rendered if it's missing.
+func (host *Host) Build(outdir string, incremental bool) (*BuildStats, error) {
+	stats := &BuildStats{}
+
+	// Without a manifest nothing is known about includes.
+	previous, _ := readBuildManifest(outdir)
+
+	manifest := buildManifest{}
+
+	docroot, err := host.getContentPath()
+	if err != nil {
+		return nil, err
+	}
+
+	// Walk returns clean paths.
+	docroot = filepath.Clean(docroot)
+
+	pages, err := host.buildPages(docroot, outdir)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool)
+	for _, p := range pages {
+		known[p.URL] = true
+	}
+
+	// Templates and settings affect every page.
+	common := []string{host.siteFile()}
+	for name := range host.Templates {
+		common = append(common, host.TemplateRoot+pathSeparator+name)
+	}
+	sort.Strings(common)
+	commonModTime := newestModTime(common...)
+
+	for _, p := range pages {
+		localFile, stat := guessFile(p.File, true)
+		if stat == nil {
+			continue
+		}
+
+		fileDir := localFile
+		if !stat.IsDir() {
+			fileDir = path.Dir(localFile)
+		}
+		fileDir = strings.TrimRight(fileDir, pathSeparator) + pathSeparator
+
+		if included, ok := previous[p.URL]; incremental && ok {
+			since := newestModTime(append(dependencies(localFile, fileDir), included...)...)
+			if commonModTime.After(since) {
+				since = commonModTime
+			}
+			if isUpToDate(p.Output, since) {
+				manifest[p.URL] = included
+				stats.Skipped++
+				continue
+			}
+		}
+
+		req := &http.Request{
+			Method:     "GET",
+			URL:        &url.URL{Path: host.Path + p.URL},
+			Host:       host.buildName(),
+			RequestURI: host.Path + p.URL,
+			Proto:      "HTTP/1.1",
+			Header:     http.Header{},
+		}
+
+		var buf bytes.Buffer
+		ctx := renderContext{host: host, req: req, deps: &cache.Deps{}}
+		if err = host.render(&buf, ctx, host.createPage(ctx, docroot, localFile, stat)); err != nil {
+			return nil, fmt.Errorf("Could not render %s: %q", p.URL, err)
+		}
+
+		manifest[p.URL] = ctx.deps.Files
+
+		if err = os.MkdirAll(filepath.Dir(p.Output), 0755); err != nil {
+			return nil, err
+		}
+
+		out := host.rewriteLinks(p.URL, buf.Bytes(), known)
+
+		if err = ioutil.WriteFile(p.Output, out, 0644); err != nil {
+			return nil, err
+		}
+
+		stats.Rendered++
+	}
+
+	if err = host.copyWebroot(outdir, incremental, stats); err != nil {
+		return nil, fmt.Errorf("Could not copy webroot: %q", err)
+	}
+
+	if err = os.MkdirAll(outdir, 0755); err != nil {
+		return nil, err
+	}
+
+	if err = manifest.write(outdir); err != nil {
+		return nil, fmt.Errorf("Could not write build manifest: %q", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"net/http"
+	"path/filepath"
+
+	"menteslibres.net/luminos/cache"
+	"menteslibres.net/luminos/page"
+)
+
+// EnableCache keeps rendered pages in the given cache, which may be shared
+// with other hosts. The content and templates directories are watched, so
+// pages are rendered again when their files change.
+func (host *Host) EnableCache(c *cache.Cache) {
+	host.mu.Lock()
+	host.cache = c
+	host.mu.Unlock()
+
+	if host.Watcher == nil {
+		return
+	}
+
+	dirs := []string{}
+
+	if docroot, err := host.getContentPath(); err == nil {
+		dirs = append(dirs, docroot)
+	}
+
+	if host.TemplateRoot != "" {
+		dirs = append(dirs, host.TemplateRoot)
+	}
+
+	for _, dir := range dirs {
+		if err := host.Watcher.WatchTree(dir); err != nil {
+			host.log.Errorf("Could not watch %s: %q", dir, err)
+		}
+	}
+}
+
+// pageCache returns the cache of rendered pages, nil if caching is disabled.
+func (host *Host) pageCache() *cache.Cache {
+	host.mu.RLock()
+	defer host.mu.RUnlock()
+	return host.cache
+}
+
+// cacheKey returns the key of a rendered page. Pages depend on the requested
+// host name, since that's what the url function uses.
+func (host *Host) cacheKey(req *http.Request) string {
+	return host.Name + "\x00" + req.Host + "\x00" + req.URL.Path
+}
+
+// cached returns a rendered page from the given cache, if present.
+func (host *Host) cached(c *cache.Cache, key string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	if v, ok := c.Get(key); ok {
+		cacheLookups.Inc("render", "hit")
+		return v.([]byte), true
+	}
+	cacheLookups.Inc("render", "miss")
+	return nil, false
+}
+
+// store adds a rendered page to the given cache. Besides the files that were
+// included by templates, pages depend on their content, header and footer
+// files, on the directories their menus list, on templates and on site
+// settings.
+func (host *Host) store(c *cache.Cache, key string, body []byte, deps *cache.Deps, p *page.Page, gen uint64) {
+	if c == nil {
+		return
+	}
+
+	// Included files may be outside of the watched directories, those that are
+	// already watched are skipped by the watcher.
+	for _, file := range deps.Files {
+		host.Watcher.Watch(file)
+	}
+
+	dir := filepath.Clean(p.FileDir)
+
+	deps.Add(p.FilePath, host.siteFile())
+	deps.Trees = append(deps.Trees, dir)
+	deps.Dirs = append(deps.Dirs, filepath.Dir(dir))
+
+	if host.TemplateRoot != "" {
+		deps.Trees = append(deps.Trees, host.TemplateRoot)
+	}
+
+	c.Put(key, body, deps, gen)
+}
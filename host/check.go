@@ -0,0 +1,269 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template/parse"
+
+	"menteslibres.net/gosexy/yaml"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/logger"
+)
+
+// Matches line numbers on template errors.
+var templateErrorPattern = regexp.MustCompile(`^template: [^:]*:(\d+):(?:\d+:)? ?(.*)$`)
+
+// settingCall is a call to the setting or settings template functions with a
+// literal path.
+type settingCall struct {
+	Path string
+	File string
+	Line int
+}
+
+// templateProblem converts a template error into a problem.
+func templateProblem(file string, err error) config.Problem {
+	p := config.Problem{File: file, Message: err.Error()}
+	if m := templateErrorPattern.FindStringSubmatch(err.Error()); m != nil {
+		p.Line, _ = strconv.Atoi(m[1])
+		p.Message = m[2]
+	}
+	return p
+}
+
+// nodeLine returns the line of a node within its template.
+func nodeLine(tree *parse.Tree, node parse.Node) int {
+	location, _ := tree.ErrorContext(node)
+	chunks := strings.Split(location, ":")
+	if len(chunks) < 2 {
+		return 0
+	}
+	line, _ := strconv.Atoi(chunks[len(chunks)-2])
+	return line
+}
+
+// findSettingCalls walks a template tree looking for setting and settings
+// calls with a literal path.
+func findSettingCalls(file string, tree *parse.Tree, node parse.Node, calls []settingCall) []settingCall {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return calls
+		}
+		for _, child := range n.Nodes {
+			calls = findSettingCalls(file, tree, child, calls)
+		}
+	case *parse.ActionNode:
+		calls = findSettingCalls(file, tree, n.Pipe, calls)
+	case *parse.IfNode:
+		calls = findSettingCalls(file, tree, &n.BranchNode, calls)
+	case *parse.RangeNode:
+		calls = findSettingCalls(file, tree, &n.BranchNode, calls)
+	case *parse.WithNode:
+		calls = findSettingCalls(file, tree, &n.BranchNode, calls)
+	case *parse.BranchNode:
+		calls = findSettingCalls(file, tree, n.Pipe, calls)
+		calls = findSettingCalls(file, tree, n.List, calls)
+		calls = findSettingCalls(file, tree, n.ElseList, calls)
+	case *parse.TemplateNode:
+		calls = findSettingCalls(file, tree, n.Pipe, calls)
+	case *parse.PipeNode:
+		if n == nil {
+			return calls
+		}
+		for _, cmd := range n.Cmds {
+			calls = findSettingCalls(file, tree, cmd, calls)
+		}
+	case *parse.CommandNode:
+		if len(n.Args) > 1 {
+			ident, ok := n.Args[0].(*parse.IdentifierNode)
+			str, isString := n.Args[1].(*parse.StringNode)
+			if ok && isString && (ident.Ident == "setting" || ident.Ident == "settings") {
+				calls = append(calls, settingCall{Path: str.Text, File: file, Line: nodeLine(tree, n)})
+			}
+		}
+		for _, arg := range n.Args {
+			calls = findSettingCalls(file, tree, arg, calls)
+		}
+	}
+	return calls
+}
+
+// checkTemplate parses a template file with the host's function map.
+func (host *Host) checkTemplate(file string) ([]settingCall, *config.Problem) {
+	text, err := readFile(file)
+	if err != nil {
+		return nil, &config.Problem{File: file, Message: err.Error()}
+	}
+
+	parsed, err := template.New(filepath.Base(file)).Funcs(host.funcMap).Parse(fixDeprecatedSyntax(text))
+	if err != nil {
+		p := templateProblem(file, err)
+		return nil, &p
+	}
+
+	var calls []settingCall
+	for _, t := range parsed.Templates() {
+		if t.Tree != nil {
+			calls = findSettingCalls(file, t.Tree, t.Tree.Root, calls)
+		}
+	}
+
+	return calls, nil
+}
+
+// Check validates the host at the given directory without starting it: it
+// validates site.yaml, looks for the content directory, parses all templates
+// and verifies that literal paths given to setting and settings exist.
+func Check(name string, root string) config.Problems {
+	var problems config.Problems
+
+	host := &Host{
+		Name:         name,
+		DocumentRoot: root,
+		Templates:    make(map[string]*template.Template),
+		log:          logger.With("host", name),
+	}
+
+	host.initFuncMap()
+
+	file := host.siteFile()
+
+	doc, err := config.Open(file)
+	if err != nil {
+		problem := config.ProblemFor(file, err)
+		if _, statErr := os.Stat(file); os.IsNotExist(statErr) {
+			problem.Message = "Settings file was not found, defaults will be used."
+			problem.Warning = true
+		}
+		problems = append(problems, problem)
+	}
+
+	host.Settings, host.Config = yaml.New(), config.NewSiteConfig()
+
+	// Problems found while reading settings into a SiteConfig, like invalid
+	// proxy URLs, that the schema alone does not catch.
+	var siteProblems config.Problems
+
+	if doc != nil {
+		if settings, err := rawSettings(file, doc); err == nil {
+			host.Settings = settings
+		}
+		var conf *config.SiteConfig
+		if conf, siteProblems = doc.SiteConfig(); conf != nil {
+			host.Config = conf
+		}
+	}
+
+	docroot, err := host.getContentPath()
+	if err != nil {
+		problems = append(problems, config.Problem{File: file, Message: err.Error()})
+	}
+
+	tpldir := host.templateDir()
+
+	var calls []settingCall
+
+	files, err := filepath.Glob(filepath.Join(tpldir, "*.tpl"))
+	if err != nil || len(files) == 0 {
+		problems = append(problems, config.Problem{File: tpldir, Message: "No templates were found, the default theme will be used.", Warning: true})
+	}
+
+	if docroot != "" {
+		filepath.Walk(docroot, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasSuffix(path, ".tpl") {
+				files = append(files, path)
+			}
+			return nil
+		})
+	}
+
+	hasIndex := false
+
+	for _, file := range files {
+		if filepath.Base(file) == "index.tpl" && filepath.Dir(file) == filepath.Clean(tpldir) {
+			hasIndex = true
+		}
+		found, p := host.checkTemplate(file)
+		if p != nil {
+			problems = append(problems, *p)
+		}
+		calls = append(calls, found...)
+	}
+
+	if len(files) > 0 && !hasIndex {
+		problems = append(problems, config.Problem{File: tpldir, Message: "Template index.tpl could not be found, the default theme will be used.", Warning: true})
+	}
+
+	if doc == nil {
+		return problems
+	}
+
+	// Sections that are read by templates are not unknown.
+	schema := &config.Schema{
+		Kind: config.SiteSchema.Kind,
+		Keys: make(map[string]*config.Schema),
+	}
+	for key, value := range config.SiteSchema.Keys {
+		schema.Keys[key] = value
+	}
+	for _, call := range calls {
+		section := strings.Split(call.Path, "/")[0]
+		if _, ok := schema.Keys[section]; !ok {
+			schema.Keys[section] = &config.Schema{Kind: config.Any}
+		}
+	}
+
+	validated := doc.Validate(schema)
+	problems = append(problems, validated...)
+
+	// Schema problems were already reported.
+	reported := make(map[config.Problem]bool)
+	for _, p := range validated {
+		reported[p] = true
+	}
+	for _, p := range siteProblems {
+		if !reported[p] {
+			problems = append(problems, p)
+		}
+	}
+
+	for _, call := range calls {
+		if !doc.Has(strings.Split(call.Path, "/")...) {
+			problems = append(problems, config.Problem{
+				File:    call.File,
+				Line:    call.Line,
+				Message: fmt.Sprintf("Setting %q is not defined in %s, it will be empty.", call.Path, file),
+				Warning: true,
+			})
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,76 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"menteslibres.net/luminos/cache"
+)
+
+// renderContext holds the request a page is being rendered for. Template
+// functions that depend on the request, like url, are bound to a new context
+// on every render, so a host can render many pages at the same time.
+type renderContext struct {
+	host *Host
+	req  *http.Request
+	// Files the page is made from, nil if they are not needed.
+	deps *cache.Deps
+}
+
+// url returns an absolute URL on the host name that was requested.
+func (ctx renderContext) url(url string) string {
+	if ctx.host.isExternalLink(url) {
+		return url
+	}
+	if ctx.req == nil {
+		return "/" + strings.TrimLeft(url, "/")
+	}
+	return "//" + ctx.req.Host + "/" + strings.TrimLeft(url, "/")
+}
+
+// funcs returns the function map for templates rendered within this context.
+func (ctx renderContext) funcs() template.FuncMap {
+	host := ctx.host
+	return template.FuncMap{
+		"url":    ctx.url,
+		"anchor": func(a, b string) template.HTML { return host.anchor(a, b) },
+		"asset":  func(s string) string { return host.asset(s) },
+		"include": func(f string) string {
+			file := host.DocumentRoot + "/" + f
+			if ctx.deps != nil {
+				ctx.deps.Add(file)
+			}
+			s, err := readFile(file)
+			if err != nil {
+				host.log.Warnf("readFile: %q", err)
+			}
+			return s
+		},
+		"setting":  func(s string) interface{} { return host.setting(s) },
+		"settings": func(s string) []interface{} { return host.settings(s) },
+		"js":       javascriptText,
+		"html":     htmlText,
+	}
+}
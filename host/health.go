@@ -0,0 +1,74 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"sort"
+)
+
+// setLoadError remembers the error of the last attempt to load a file, a nil
+// error clears it.
+func (host *Host) setLoadError(file string, err error) {
+	host.mu.Lock()
+	defer host.mu.Unlock()
+	if err == nil {
+		delete(host.loadErrors, file)
+		return
+	}
+	if host.loadErrors == nil {
+		host.loadErrors = make(map[string]error)
+	}
+	host.loadErrors[file] = err
+}
+
+// Ready returns the reasons why the host can't serve its site as intended, or
+// nil if it can: its own settings file and index.tpl template must have been
+// loaded, other files must have been loaded without errors and its content
+// directory must exist. Sites that fall back to default settings or to the
+// default theme are not ready.
+func (host *Host) Ready() []string {
+	var problems []string
+
+	host.mu.RLock()
+	for file, err := range host.loadErrors {
+		problems = append(problems, fmt.Sprintf("%s: %v", file, err))
+	}
+	siteLoaded, defaultTheme := host.siteLoaded, host.defaultTheme
+	host.mu.RUnlock()
+
+	sort.Strings(problems)
+
+	if !siteLoaded {
+		problems = append(problems, fmt.Sprintf("Settings file %s was not loaded.", host.siteFile()))
+	}
+
+	if defaultTheme || host.template("index.tpl") == nil {
+		problems = append(problems, "Template index.tpl was not loaded.")
+	}
+
+	if _, err := host.getContentPath(); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	return problems
+}
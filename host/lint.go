@@ -0,0 +1,268 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Matches headings after Page.ProcessContent added anchors to them.
+	lintHeadingPattern = regexp.MustCompile(`<h(\d)><a href="#([^"]*)" name="[^"]*">(.*?)</a></h\d>`)
+	// Matches img tags.
+	lintImagePattern = regexp.MustCompile(`<img\b[^>]*>`)
+	// Matches the alt attribute.
+	lintAltPattern = regexp.MustCompile(`\balt="([^"]*)"`)
+)
+
+// Lint rules.
+const (
+	LintBrokenLink      = "broken-link"
+	LintBrokenAnchor    = "broken-anchor"
+	LintMissingAsset    = "missing-asset"
+	LintImageAlt        = "image-alt"
+	LintDuplicateAnchor = "duplicate-anchor"
+	LintHeadingLevel    = "heading-level"
+)
+
+// LintIssue is a problem found in a page.
+type LintIssue struct {
+	// Content file.
+	File string `json:"file"`
+	// Line of the content file, 0 if unknown.
+	Line int `json:"line,omitempty"`
+	// URL of the page.
+	URL string `json:"url"`
+	// Rule that was broken.
+	Rule string `json:"rule"`
+	// Description of the problem.
+	Message string `json:"message"`
+}
+
+// String returns the issue as a "file:line: [rule] message" string.
+func (i LintIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s:%d: [%s] %s", i.File, i.Line, i.Rule, i.Message)
+	}
+	return fmt.Sprintf("%s: [%s] %s", i.File, i.Rule, i.Message)
+}
+
+// lintSource helps finding line numbers on a content file.
+type lintSource []string
+
+// line returns the first line that contains s, or 0.
+func (src lintSource) line(s string) int {
+	return src.lineAfter(s, 0)
+}
+
+// lineAfter returns the first line after the given one that contains s, or 0.
+func (src lintSource) lineAfter(s string, after int) int {
+	for i := after; i < len(src); i++ {
+		if strings.Contains(src[i], s) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// isMarkdownFile returns true for files that are rendered with markdown.
+func isMarkdownFile(name string) bool {
+	return strings.HasSuffix(name, ".md") || strings.HasSuffix(name, ".md.tpl")
+}
+
+// resolveLocal resolves a link found on the page at pageURL. It returns the
+// target path, relative to the host's path, and the fragment. ok is false for
+// links that point outside the host.
+func (host *Host) resolveLocal(pageURL string, link string) (target string, fragment string, ok bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Opaque != "" || u.Host != "" {
+		return "", "", false
+	}
+
+	if u.Path == "" {
+		return "", u.Fragment, true
+	}
+
+	base := &url.URL{Path: host.Path + pageURL}
+	p := base.ResolveReference(u).Path
+
+	if host.Path != "" {
+		if p != host.Path && !strings.HasPrefix(p, host.Path+"/") {
+			return "", "", false
+		}
+		p = strings.TrimPrefix(p, host.Path)
+	}
+
+	if p == "" {
+		p = "/"
+	}
+
+	return p, u.Fragment, true
+}
+
+// exists returns true if the given path would be served by the host, either
+// from the webroot or as a page.
+func (host *Host) exists(docroot string, target string, asset bool) bool {
+	file := strings.TrimRight(target, "/")
+
+	if stat, err := os.Stat(host.webroot() + file); err == nil && !stat.IsDir() {
+		return true
+	}
+
+	if asset {
+		// Assets within the content directory are not served as files.
+		return false
+	}
+
+	_, stat := guessFile(docroot+file, true)
+
+	return stat != nil
+}
+
+// lintPage checks the content of a rendered page.
+func (host *Host) lintPage(docroot string, p *buildPage) []LintIssue {
+	var issues []LintIssue
+
+	localFile, stat := guessFile(p.File, true)
+	if stat == nil || stat.IsDir() || !isMarkdownFile(localFile) {
+		return nil
+	}
+
+	buf, _ := ioutil.ReadFile(localFile)
+	src := lintSource(strings.Split(string(buf), "\n"))
+
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: host.Path + p.URL},
+		Host:   host.buildName(),
+		Header: http.Header{},
+	}
+
+	pg := host.createPage(renderContext{host: host, req: req}, docroot, localFile, stat)
+	content := string(pg.Content)
+
+	report := func(line int, rule string, format string, args ...interface{}) {
+		issues = append(issues, LintIssue{
+			File:    localFile,
+			Line:    line,
+			URL:     p.URL,
+			Rule:    rule,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Headings: duplicate anchors and skipped levels.
+	anchors := make(map[string]int)
+	lines := make(map[string]int)
+	level := 0
+
+	for _, m := range lintHeadingPattern.FindAllStringSubmatch(content, -1) {
+		l, _ := strconv.Atoi(m[1])
+		id, text := m[2], m[3]
+		line := src.lineAfter(text, lines[id])
+		lines[id] = line
+
+		if anchors[id]++; anchors[id] == 2 {
+			report(line, LintDuplicateAnchor, "Heading %q produces the anchor #%s, which is already used on this page.", text, id)
+		}
+
+		if level > 0 && l > level+1 {
+			report(line, LintHeadingLevel, "Heading %q is h%d, but the previous heading is h%d.", text, l, level)
+		}
+
+		level = l
+	}
+
+	// Images without alt text.
+	for _, img := range lintImagePattern.FindAllString(content, -1) {
+		if m := lintAltPattern.FindStringSubmatch(img); m == nil || strings.TrimSpace(m[1]) == "" {
+			image := ""
+			if s := linkAttributePattern.FindStringSubmatch(img); s != nil {
+				image = s[2]
+			}
+			report(src.line(image), LintImageAlt, "Image %s has no alt text.", image)
+		}
+	}
+
+	// Links and assets.
+	for _, m := range linkAttributePattern.FindAllStringSubmatch(content, -1) {
+		attr, link := m[1], m[2]
+
+		target, fragment, ok := host.resolveLocal(p.URL, link)
+		if !ok {
+			continue
+		}
+
+		if target == "" {
+			if fragment != "" && anchors[fragment] == 0 && !strings.Contains(content, `name="`+fragment+`"`) && !strings.Contains(content, `id="`+fragment+`"`) {
+				report(src.line(link), LintBrokenAnchor, "Anchor %s does not exist on this page.", link)
+			}
+			continue
+		}
+
+		if attr == "src" {
+			if !host.exists(docroot, target, true) {
+				report(src.line(link), LintMissingAsset, "Asset %s does not exist in the webroot.", link)
+			}
+			continue
+		}
+
+		if !host.exists(docroot, target, false) {
+			report(src.line(link), LintBrokenLink, "Link %s points to a page that does not exist.", link)
+		}
+	}
+
+	return issues
+}
+
+// Lint renders every markdown page of the host and reports broken internal
+// links, missing assets, images without alt text, duplicate heading anchors
+// and skipped heading levels.
+func (host *Host) Lint() ([]LintIssue, error) {
+	docroot, err := host.getContentPath()
+	if err != nil {
+		return nil, err
+	}
+
+	docroot = filepath.Clean(docroot)
+
+	pages, err := host.buildPages(docroot, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var issues []LintIssue
+
+	for _, p := range pages {
+		issues = append(issues, host.lintPage(docroot, p)...)
+	}
+
+	return issues, nil
+}
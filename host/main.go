@@ -27,18 +27,22 @@ import (
 	"bytes"
 	"errors"
 	"html/template"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"path"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/russross/blackfriday"
-	"menteslibres.net/gosexy/to"
 	"menteslibres.net/gosexy/yaml"
+	"menteslibres.net/luminos/cache"
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/livereload"
+	"menteslibres.net/luminos/logger"
 	"menteslibres.net/luminos/page"
 	"menteslibres.net/luminos/watcher"
 )
@@ -61,23 +65,41 @@ type Host struct {
 	DocumentRoot string
 	// Main path
 	Path string
-	// Settings
+	// Settings, as read by templates.
 	Settings *yaml.Yaml
-	// Templates (not fully functional yet)
+	// Typed settings.
+	Config *config.SiteConfig
+	// Templates (not fully functional yet). They are never executed
+	// directly, each render works on a clone.
 	Templates map[string]*template.Template
 	// Function map for templates.
 	template.FuncMap
-	// Standard request.
-	*http.Request
-	// Standard response writer.
-	http.ResponseWriter
-	// Function map
+	// Function map used to parse templates.
 	funcMap template.FuncMap
+	// Errors of the last attempt to load a file, by file name.
+	loadErrors map[string]error
+	// Services mounted on the site, longest prefixes first.
+	proxies []*proxy
+	// Whether Settings come from the site's own settings file rather than
+	// defaults.
+	siteLoaded bool
+	// Whether index.tpl is the default theme rather than the site's own.
+	defaultTheme bool
+	// Guards Settings, Config, Templates, loadErrors, proxies, siteLoaded,
+	// defaultTheme, liveReload and cache, which are replaced when files change
+	// or read by the file watcher.
+	mu sync.RWMutex
 	// File watcher
 	//Watcher *fsnotify.Watcher
 	Watcher *watcher.Watcher
 	// Template root
 	TemplateRoot string
+	// Live reload hub, only set in development mode.
+	liveReload *livereload.Hub
+	// Logger for messages about this host.
+	log *logger.Logger
+	// Rendered pages, nil if caching is disabled.
+	cache *cache.Cache
 }
 
 // Expected extensions. Elements on the left have precedence.
@@ -105,7 +127,7 @@ func fixDeprecatedSyntax(s string) string {
 func (host *Host) getContentPath() (string, error) {
 	var directories []string
 
-	contentdir := to.String(host.Settings.Get("content", "markdown"))
+	contentdir := host.siteConfig().Content.Markdown
 	if contentdir == "" {
 		directories = []string{
 			"content",
@@ -122,9 +144,32 @@ func (host *Host) getContentPath() (string, error) {
 		}
 	}
 
+	// A plain directory of markdown files is its own content directory.
+	if contentdir == "" {
+		return host.DocumentRoot, nil
+	}
+
 	return "", errors.New(`Content directory was not found.`)
 }
 
+// webroot returns the directory of static files.
+func (host *Host) webroot() string {
+	webrootdir := host.siteConfig().Content.Webroot
+
+	if webrootdir == "" {
+		webrootdir = "webroot"
+
+		// Plain directories keep images and other files next to the pages.
+		if _, err := os.Stat(host.DocumentRoot + pathSeparator + webrootdir); err != nil {
+			if docroot, _ := host.getContentPath(); docroot == host.DocumentRoot {
+				return host.DocumentRoot
+			}
+		}
+	}
+
+	return host.DocumentRoot + pathSeparator + webrootdir
+}
+
 // readFile attempts to read a file from disk and returns its contents.
 func readFile(file string) (string, error) {
 	var buf []byte
@@ -137,9 +182,11 @@ func readFile(file string) (string, error) {
 	return string(buf), nil
 }
 
-// Close removes the watcher that is currently associated with the host.
+// Close stops the watcher that is currently associated with the host, and the
+// goroutine that receives its events.
 func (host *Host) Close() {
 	host.Watcher.Close()
+	host.setProxies(nil)
 }
 
 // asset returns a relative URL.
@@ -155,12 +202,25 @@ func (host *Host) asset(assetURL string) string {
 	return assetURL
 }
 
-// url returns an absolute URL.
-func (host *Host) url(url string) string {
-	if host.isExternalLink(url) == false {
-		return "//" + host.Request.Host + "/" + strings.TrimLeft(url, "/")
-	}
-	return url
+// siteConfig returns the typed settings of the host.
+func (host *Host) siteConfig() *config.SiteConfig {
+	host.mu.RLock()
+	defer host.mu.RUnlock()
+	return host.Config
+}
+
+// siteSettings returns the settings of the host, as read by templates.
+func (host *Host) siteSettings() *yaml.Yaml {
+	host.mu.RLock()
+	defer host.mu.RUnlock()
+	return host.Settings
+}
+
+// template returns the named template, or nil if it was not loaded.
+func (host *Host) template(name string) *template.Template {
+	host.mu.RLock()
+	defer host.mu.RUnlock()
+	return host.Templates[name]
 }
 
 // isExternalLink returns true if the given URL is outside this host.
@@ -175,7 +235,7 @@ func (host *Host) setting(path string) interface{} {
 	for i := range route {
 		args[i] = route[i]
 	}
-	setting := host.Settings.Get(args...)
+	setting := host.siteSettings().Get(args...)
 	return fixSetting(setting)
 }
 
@@ -203,12 +263,16 @@ func (host *Host) settings(path string) []interface{} {
 	for i := range route {
 		args[i] = route[i]
 	}
-	val := host.Settings.Get(args...)
+	val := host.siteSettings().Get(args...)
 	if val == nil {
 		return nil
 	}
 
-	ival := val.([]interface{})
+	ival, ok := val.([]interface{})
+	if !ok {
+		host.log.Warnf("Setting %q is not a list.", path)
+		return nil
+	}
 
 	for i := range ival {
 		ival[i] = fixSetting(ival[i])
@@ -267,8 +331,9 @@ func guessFile(file string, descend bool) (string, os.FileInfo) {
 }
 
 // readFile opens a file and reads its contents, if the file has the .md
-// extension the contents are parsed and HTML is returned.
-func (host *Host) readFile(file string) ([]byte, error) {
+// extension the contents are parsed and HTML is returned. Files with the .tpl
+// extension are executed as templates for the given request.
+func (host *Host) readFile(file string, ctx renderContext) ([]byte, error) {
 	var buf []byte
 	var err error
 
@@ -278,7 +343,7 @@ func (host *Host) readFile(file string) ([]byte, error) {
 
 	if strings.HasSuffix(file, ".tpl") {
 		var out bytes.Buffer
-		tpl, err := template.New("").Funcs(host.funcMap).Parse(string(buf))
+		tpl, err := template.New("").Funcs(ctx.funcs()).Parse(string(buf))
 		if err != nil {
 			return nil, err
 		}
@@ -296,29 +361,162 @@ func (host *Host) readFile(file string) ([]byte, error) {
 	return buf, nil
 }
 
-func chunk(value string) string {
-	if value == "" {
-		return "-"
+// createPage creates a page for a content file or directory, including its
+// header, footer, menus and titles.
+func (host *Host) createPage(ctx renderContext, docroot string, localFile string, stat os.FileInfo) *page.Page {
+	req := ctx.req
+	p := &page.Page{}
+
+	p.FilePath = localFile
+	p.BasePath = req.URL.Path
+
+	relPath := localFile[len(docroot):]
+
+	if stat.IsDir() == false {
+		p.FileDir = path.Dir(localFile)
+		p.BasePath = path.Dir(relPath)
+	} else {
+		p.FileDir = localFile
+		p.BasePath = relPath
+	}
+
+	// Reading contents.
+	content, err := host.readFile(localFile, ctx)
+
+	if err == nil {
+		p.Content = template.HTML(content)
+	}
+
+	p.FileDir = strings.TrimRight(p.FileDir, pathSeparator) + pathSeparator
+	p.BasePath = strings.TrimRight(p.BasePath, pathSeparator) + pathSeparator
+
+	// werc-like header and footer.
+	hfile, hstat := guessFile(p.FileDir+"_header", true)
+
+	if hstat != nil {
+		hcontent, herr := host.readFile(hfile, ctx)
+		if herr == nil {
+			p.ContentHeader = template.HTML(hcontent)
+		}
+	}
+
+	if strings.Trim(host.Path, pathSeparator) == strings.Trim(req.URL.Path, pathSeparator) {
+		p.IsHome = true
+	}
+
+	// werc-like header and footer.
+	ffile, fstat := guessFile(p.FileDir+"_footer", true)
+
+	if fstat != nil {
+		fcontent, ferr := host.readFile(ffile, ctx)
+		if ferr == nil {
+			p.ContentFooter = template.HTML(fcontent)
+		}
+	}
+
+	p.CreateBreadCrumb()
+	p.CreateMenu()
+	p.CreateSideMenu()
+
+	p.ProcessContent()
+
+	return p
+}
+
+// render executes the index.tpl template for the given page and context. In
+// live reload mode, the reload script is added at the end of the body.
+func (host *Host) render(w io.Writer, ctx renderContext, p *page.Page) error {
+	master := host.template("index.tpl")
+	if master == nil {
+		return errors.New("Missing index.tpl template.")
+	}
+
+	// Templates are cloned so every request gets its own url function.
+	tpl, err := master.Clone()
+	if err != nil {
+		return err
+	}
+	tpl.Funcs(ctx.funcs())
+
+	if host.liveReloadHub() == nil {
+		return tpl.Execute(w, p)
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, p); err != nil {
+		return err
+	}
+
+	out := buf.Bytes()
+	script := []byte(livereload.Script)
+
+	if i := bytes.LastIndex(bytes.ToLower(out), []byte("</body>")); i > -1 {
+		out = append(out[:i], append(script, out[i:]...)...)
+	} else {
+		out = append(out, script...)
+	}
+
+	_, err = w.Write(out)
+	return err
+}
+
+// liveReloadHub returns the live reload hub, nil unless live reload is enabled.
+func (host *Host) liveReloadHub() *livereload.Hub {
+	host.mu.RLock()
+	defer host.mu.RUnlock()
+	return host.liveReload
+}
+
+// EnableLiveReload watches the content, templates and webroot directories of
+// the host and sends changes to the given hub. Rendered pages include a script
+// that reloads them when something changes.
+func (host *Host) EnableLiveReload(hub *livereload.Hub) {
+	host.mu.Lock()
+	host.liveReload = hub
+	host.mu.Unlock()
+
+	if host.Watcher == nil {
+		return
+	}
+
+	dirs := []string{host.webroot()}
+
+	if host.TemplateRoot != "" {
+		dirs = append(dirs, host.TemplateRoot)
+	}
+
+	if docroot, err := host.getContentPath(); err == nil && docroot != dirs[0] {
+		dirs = append(dirs, docroot)
+	}
+
+	for _, dir := range dirs {
+		if err := host.Watcher.WatchTree(dir); err != nil {
+			host.log.Errorf("Could not watch %s: %q", dir, err)
+		}
 	}
-	return value
 }
 
 // ServeHTTP reads a request and creates an appropriate response.
 func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var localFile string
 
-	// TODO: Fix this non-critical race condition.  We need to save some
-	// variables in a per request basis, in particular the hostname. It may not
-	// always match the host name we gave to it (i.e: the "default" hostname). A
-	// per-request context would be useful.
-	host.Request = req
+	// Paths are joined to directories of the site, they must not leave them.
+	if containsDotDot(req.URL.Path) {
+		http.Error(w, "invalid URL path", http.StatusBadRequest)
+		return
+	}
+
+	// Services mounted on the site take precedence over its files.
+	if p := host.proxyFor(req); p != nil {
+		p.handler.ServeHTTP(w, req)
+		return
+	}
+
+	started := time.Now()
 
 	// Settings default status as not found.
 	status := http.StatusNotFound
 
-	// Default size is no size (-1).
-	size := -1
-
 	// Requested path
 	reqpath := strings.TrimRight(req.URL.Path, "/")
 
@@ -333,18 +531,8 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	reqpath = strings.TrimRight(reqpath, "/")
 
-	// Trying to match a file on webroot/
-	webrootdir := to.String(host.Settings.Get("content", "webroot"))
-
-	if webrootdir == "" {
-		webrootdir = "webroot"
-	}
-
-	// Absolute local webroot.
-	webroot := host.DocumentRoot + pathSeparator + webrootdir
-
 	// Attempt to match a request with a file in webroot/.
-	localFile = webroot + pathSeparator + reqpath
+	localFile = host.webroot() + pathSeparator + reqpath
 
 	stat, err := os.Stat(localFile)
 
@@ -353,8 +541,9 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		if stat.IsDir() == false {
 			// Exists and it's not a directory, let's serve it.
 			status = http.StatusOK // Changing status.
-			http.ServeFile(w, req, localFile)
-			size = int(stat.Size())
+			host.observe("lookup", started)
+			host.setCacheControl(w, reqpath)
+			host.serveFile(w, req, localFile)
 		}
 	}
 
@@ -373,6 +562,8 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 		localFile, stat = guessFile(testFile, true)
 
+		host.observe("lookup", started)
+
 		if stat != nil {
 
 			if reqpath != "" {
@@ -392,68 +583,56 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 				}
 			}
 
-			// Creating a page.
-			p := &page.Page{}
+			// Clients that already have this version of the page don't need
+			// it to be rendered again.
+			v := host.pageValidators(req, localFile, stat)
 
-			p.FilePath = localFile
-			p.BasePath = req.URL.Path
-
-			relPath := localFile[len(docroot):]
-
-			if stat.IsDir() == false {
-				p.FileDir = path.Dir(localFile)
-				p.BasePath = path.Dir(relPath)
-			} else {
-				p.FileDir = localFile
-				p.BasePath = relPath
+			if v.notModified(req) {
+				v.setHeaders(w)
+				host.setCacheControl(w, reqpath)
+				w.WriteHeader(http.StatusNotModified)
+				return
 			}
 
-			// Reading contents.
-			content, err := host.readFile(localFile)
+			key := host.cacheKey(req)
+			c := host.pageCache()
 
-			if err == nil {
-				p.Content = template.HTML(content)
+			if body, ok := host.cached(c, key); ok {
+				v.setHeaders(w)
+				host.setCacheControl(w, reqpath)
+				w.Write(body)
+				return
 			}
 
-			p.FileDir = strings.TrimRight(p.FileDir, pathSeparator) + pathSeparator
-			p.BasePath = strings.TrimRight(p.BasePath, pathSeparator) + pathSeparator
-
-			// werc-like header and footer.
-			hfile, hstat := guessFile(p.FileDir+"_header", true)
+			ctx := renderContext{host: host, req: req}
 
-			if hstat != nil {
-				hcontent, herr := host.readFile(hfile)
-				if herr == nil {
-					p.ContentHeader = template.HTML(hcontent)
-				}
-			}
-
-			if strings.Trim(host.Path, pathSeparator) == strings.Trim(req.URL.Path, pathSeparator) {
-				p.IsHome = true
+			var gen uint64
+			if c != nil {
+				gen = c.Generation()
+				ctx.deps = &cache.Deps{}
 			}
 
-			// werc-like header and footer.
-			ffile, fstat := guessFile(p.FileDir+"_footer", true)
+			phase := time.Now()
+			p := host.createPage(ctx, docroot, localFile, stat)
+			host.observe("markdown", phase)
 
-			if fstat != nil {
-				fcontent, ferr := host.readFile(ffile)
-				if ferr == nil {
-					p.ContentFooter = template.HTML(fcontent)
-				}
-			}
+			// Applying template. Nothing is written until the template
+			// succeeds, so errors get the right status.
+			var buf bytes.Buffer
 
-			p.CreateBreadCrumb()
-			p.CreateMenu()
-			p.CreateSideMenu()
+			phase = time.Now()
+			err = host.render(&buf, ctx, p)
+			host.observe("template", phase)
 
-			p.ProcessContent()
-
-			// Applying template.
-			if err = host.Templates["index.tpl"].Execute(w, p); err != nil {
+			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				status = http.StatusInternalServerError
 			} else {
+				v.setHeaders(w)
+				host.setCacheControl(w, reqpath)
+				w.Write(buf.Bytes())
 				status = http.StatusOK
+				host.store(c, key, buf.Bytes(), ctx.deps, p, gen)
 			}
 
 		}
@@ -462,23 +641,12 @@ func (host *Host) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if status == http.StatusNotFound {
 		http.Error(w, "Not found", http.StatusNotFound)
 	}
-
-	// Log line.
-	logLine := []string{
-		chunk(req.RemoteAddr),
-		chunk(""),
-		chunk(""),
-		chunk("[" + time.Now().Format("02/Jan/2006:15:04:05 -0700") + "]"),
-		chunk("\"" + fmt.Sprintf("%s %s %s", req.Method, req.RequestURI, req.Proto) + "\""),
-		chunk(fmt.Sprintf("%d", status)),
-		chunk(fmt.Sprintf("%d", size)),
-	}
-
-	fmt.Println(strings.Join(logLine, " "))
 }
 
-func (host *Host) loadTemplate(file string) error {
-	var err error
+func (host *Host) loadTemplate(file string) (err error) {
+	defer func() {
+		host.setLoadError(file, err)
+	}()
 
 	// Reading template file.
 	var text string
@@ -497,7 +665,12 @@ func (host *Host) loadTemplate(file string) error {
 		return err
 	}
 
+	host.mu.Lock()
 	host.Templates[name] = parsed
+	if name == "index.tpl" {
+		host.defaultTheme = false
+	}
+	host.mu.Unlock()
 
 	if host.Watcher != nil {
 		host.Watcher.RemoveWatch(file)
@@ -507,21 +680,41 @@ func (host *Host) loadTemplate(file string) error {
 	return nil
 }
 
+// templateDir returns the directory where templates are expected.
+func (host *Host) templateDir() string {
+	return host.DocumentRoot + pathSeparator + host.siteConfig().Content.Templates
+}
+
+// loadDefaultTemplate uses the built-in theme as index.tpl.
+func (host *Host) loadDefaultTemplate() error {
+	parsed, err := template.New("index.tpl").Funcs(host.funcMap).Parse(defaultTemplate)
+	if err != nil {
+		return err
+	}
+
+	host.mu.Lock()
+	host.Templates["index.tpl"] = parsed
+	host.defaultTheme = true
+	host.mu.Unlock()
+
+	host.log.Infof("Using the default theme.")
+
+	return nil
+}
+
 // loadTemplates loads templates with .tpl extension from the templates
-// directory. At this moment only index.tpl is expected.
+// directory. At this moment only index.tpl is expected, the default theme is
+// used if it's missing.
 func (host *Host) loadTemplates() error {
 	var err error
 	var fp *os.File
 
-	tpldir := to.String(host.Settings.Get("content", "templates"))
-
-	if tpldir == "" {
-		tpldir = "templates"
-	}
-
-	tplroot := host.DocumentRoot + pathSeparator + tpldir
+	tplroot := host.templateDir()
 
 	if fp, err = os.Open(tplroot); err != nil {
+		if os.IsNotExist(err) {
+			return host.loadDefaultTemplate()
+		}
 		return fmt.Errorf("Error trying to open %s: %q", tplroot, err)
 	}
 
@@ -543,14 +736,14 @@ func (host *Host) loadTemplates() error {
 			err := host.loadTemplate(file)
 
 			if err != nil {
-				log.Printf("%s: Template error in file %s: %q\n", host.Name, file, err)
+				host.log.Errorf("Template error in file %s: %q", file, err)
 			}
 
 		}
 	}
 
-	if _, ok := host.Templates["index.tpl"]; ok == false {
-		return fmt.Errorf("Template %s could not be found.", "index.tpl")
+	if host.template("index.tpl") == nil {
+		return host.loadDefaultTemplate()
 	}
 
 	return nil
@@ -629,29 +822,41 @@ func (host *Host) fileWatcher() error {
 
 			for {
 				select {
+				case <-host.Watcher.Done():
+					return
 				case ev := <-host.Watcher.Event:
 
 					if ev.IsModify() {
-						// Is settings file?
-						if ev.Name == host.DocumentRoot+pathSeparator+settingsFile {
-							log.Printf("%s: Reloading host settings %s...\n", host.Name, ev.Name)
+						// Is settings file? They are reloaded on SIGHUP, and
+						// also right away in live reload mode.
+						if host.liveReloadHub() != nil && ev.Name == host.siteFile() {
+							host.log.Infof("Reloading host settings %s...", ev.Name)
 							err := host.loadSettings()
 
 							if err != nil {
-								log.Printf("%s: Could not reload host settings: %s\n", host.Name, host.DocumentRoot+pathSeparator+settingsFile)
+								host.log.Errorf("Could not reload host settings %s: %q", ev.Name, err)
 							}
 						}
 
 						// Is a template?
 						if strings.HasPrefix(ev.Name, host.TemplateRoot) == true {
 							if strings.HasSuffix(ev.Name, ".tpl") == true {
-								log.Printf("%s: Reloading template %s\n", host.Name, ev.Name)
-								host.loadTemplate(ev.Name)
-								if err != nil {
-									log.Printf("%s: Could not reload template %s: %q\n", host.Name, ev.Name, err)
+								host.log.Infof("Reloading template %s", ev.Name)
+								if err := host.loadTemplate(ev.Name); err != nil {
+									host.log.Errorf("Could not reload template %s: %q", ev.Name, err)
 								}
 							}
 						}
+
+						// Cached pages made from this file are not valid anymore.
+						if c := host.pageCache(); c != nil {
+							c.Invalidate(ev.Name)
+						}
+
+						// Browsers in live reload mode are told about every change.
+						if hub := host.liveReloadHub(); hub != nil {
+							hub.Notify(ev.Name)
+						}
 					}
 				}
 			}
@@ -662,20 +867,66 @@ func (host *Host) fileWatcher() error {
 
 }
 
+// siteFile returns the settings file of the host: site.yaml, site.json or
+// site.toml.
+func (host *Host) siteFile() string {
+	return config.FindFile(host.DocumentRoot + pathSeparator + settingsFile)
+}
+
+// rawSettings returns settings the way templates read them, no matter the
+// format of the file.
+func rawSettings(file string, doc *config.Document) (*yaml.Yaml, error) {
+	if config.IsYAML(file) {
+		return yaml.Open(file)
+	}
+
+	y := yaml.New()
+	for key, value := range doc.Values() {
+		y.Set(key, value)
+	}
+
+	return y, nil
+}
+
 // loadSettings loads settings for the host.
-func (host *Host) loadSettings() error {
+func (host *Host) loadSettings() (err error) {
 
 	var settings *yaml.Yaml
+	var conf *config.SiteConfig
+	var loaded bool
+
+	file := host.siteFile()
 
-	file := host.DocumentRoot + pathSeparator + settingsFile
+	defer func() {
+		host.setLoadError(file, err)
+	}()
 
-	_, err := os.Stat(file)
+	_, err = os.Stat(file)
 
 	if err == nil {
-		settings, err = yaml.Open(file)
+		var doc *config.Document
+		var problems config.Problems
+		if doc, err = config.Open(file); err != nil {
+			return fmt.Errorf(`Could not parse settings file (%s): %q`, file, err)
+		}
+		conf, problems = doc.SiteConfig()
+		for _, p := range problems {
+			if p.Warning {
+				host.log.Warnf("%s", p.Error())
+			}
+		}
+		if err = problems.Err(); err != nil {
+			return fmt.Errorf(`Could not parse settings file (%s): %q`, file, err)
+		}
+		settings, err = rawSettings(file, doc)
 		if err != nil {
 			return fmt.Errorf(`Could not parse settings file (%s): %q`, file, err)
 		}
+		loaded = true
+	} else if os.IsNotExist(err) {
+		// Sites without settings use the defaults.
+		settings = yaml.New()
+		conf = config.NewSiteConfig()
 	} else {
 		return fmt.Errorf(`Error trying to open settings file (%s): %q.`, file, err)
 	}
@@ -685,19 +936,31 @@ func (host *Host) loadSettings() error {
 		host.Watcher.Watch(file)
 	}
 
+	host.mu.Lock()
 	host.Settings = settings
+	host.Config = conf
+	host.siteLoaded = loaded
+	host.mu.Unlock()
+
+	host.setProxies(host.newProxies(conf.Proxy))
 
 	return nil
 }
 
+// initFuncMap creates the function map used to parse templates. Templates are
+// executed with functions bound to the request, see render.
+func (host *Host) initFuncMap() {
+	host.funcMap = renderContext{host: host}.funcs()
+}
+
 // New creates and returns a host.
 func New(name string, root string) (*Host, error) {
 
 	_, err := os.Stat(root)
 
 	if err != nil {
-		log.Printf("Error reading directory %s: %q\n", root, err)
-		log.Printf("Checkout an example directory at https://github.com/xiam/luminos/tree/master/default\n")
+		logger.Errorf("Error reading directory %s: %q", root, err)
+		logger.Infof("Checkout an example directory at https://github.com/xiam/luminos/tree/master/default")
 
 		return nil, err
 	}
@@ -715,41 +978,29 @@ func New(name string, root string) (*Host, error) {
 		Path:         strings.TrimRight(route, "/"),
 		DocumentRoot: root,
 		Templates:    make(map[string]*template.Template),
+		log:          logger.With("host", name),
 	}
 
-	host.funcMap = template.FuncMap{
-		"url":    func(s string) string { return host.url(s) },
-		"anchor": func(a, b string) template.HTML { return host.anchor(a, b) },
-		"asset":  func(s string) string { return host.asset(s) },
-		"include": func(f string) string {
-			s, err := readFile(host.DocumentRoot + "/" + f)
-			if err != nil {
-				log.Printf("readFile: %q", err)
-			}
-			return s
-		},
-		"setting":  func(s string) interface{} { return host.setting(s) },
-		"settings": func(s string) []interface{} { return host.settings(s) },
-		"js":       javascriptText,
-		"html":     htmlText,
-	}
+	host.initFuncMap()
 
 	// Watcher
 	host.fileWatcher()
 
 	// Loading host settings
 	if err = host.loadSettings(); err != nil {
-		log.Printf("Could not start host: %s\n", name)
+		host.log.Errorf("Could not start host: %s", name)
+		host.Close()
 		return nil, err
 	}
 
 	// Loading templates.
 	if err = host.loadTemplates(); err != nil {
-		log.Printf("Could not start host: %s\n", name)
+		host.log.Errorf("Could not start host: %s", name)
+		host.Close()
 		return nil, err
 	}
 
-	log.Printf("Routing: %s -> %s\n", name, root)
+	host.log.Infof("Routing: %s -> %s", name, root)
 
 	return host, nil
 
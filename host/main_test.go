@@ -0,0 +1,584 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"menteslibres.net/luminos/cache"
+	"menteslibres.net/luminos/livereload"
+)
+
+// testHost serves a site that lives in a temporary directory.
+type testHost struct {
+	*Host
+	t    *testing.T
+	root string
+}
+
+// writeFiles creates or replaces files below root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, text := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestHost writes files to a temporary directory and creates a host for
+// it.
+func newTestHost(t *testing.T, files map[string]string) *testHost {
+	root, err := ioutil.TempDir("", "luminos-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, root, files)
+
+	h, err := New("default", root)
+	if err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+
+	return &testHost{Host: h, t: t, root: root}
+}
+
+// Close closes the host and removes its files.
+func (h *testHost) Close() {
+	h.Host.Close()
+	os.RemoveAll(h.root)
+}
+
+// write creates or replaces files of the site.
+func (h *testHost) write(files map[string]string) {
+	writeFiles(h.t, h.root, files)
+}
+
+// get serves a GET request, headers are given as name and value pairs.
+func (h *testHost) get(url string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", url, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestConcurrentRender(t *testing.T) {
+	h := newTestHost(t, map[string]string{
+		"content/index.md":    "# Home\n",
+		"templates/index.tpl": `<a href="{{ url "/about" }}">{{ .Content }}</a>`,
+	})
+	defer h.Close()
+
+	names := []string{"example.org", "example.com", "localhost:9000"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		name := names[i%len(names)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			expected := `href="//` + name + `/about"`
+			if body := h.get("http://" + name + "/").Body.String(); !strings.Contains(body, expected) {
+				t.Errorf("Expected %s in %q.", expected, body)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRenderCache(t *testing.T) {
+	h := newTestHost(t, map[string]string{
+		"index.md": "# Before\n",
+	})
+	defer h.Close()
+
+	pages := cache.New(10)
+	h.EnableCache(pages)
+
+	if body := h.get("http://example.org/").Body.String(); !strings.Contains(body, "Before") || pages.Len() != 1 {
+		t.Fatalf("Expecting a cached page, got %d entries.", pages.Len())
+	}
+
+	// Modification times may have a resolution of one second.
+	time.Sleep(time.Second)
+
+	h.write(map[string]string{"index.md": "# After\n"})
+
+	for i := 0; i < 20 && pages.Len() > 0; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if body := h.get("http://example.org/").Body.String(); !strings.Contains(body, "After") {
+		t.Fatalf("Expecting the page to be rendered again, got %q.", body)
+	}
+}
+
+func TestEnableWhileWatching(t *testing.T) {
+	h := newTestHost(t, map[string]string{
+		"content/index.md":    "# Home\n",
+		"templates/index.tpl": "<body>{{ .Content }}</body>",
+	})
+	defer h.Close()
+
+	// waitFor changes the template and waits until pages use it.
+	waitFor := func(text string) string {
+		// Modification times may have a resolution of one second.
+		time.Sleep(time.Second)
+
+		h.write(map[string]string{"templates/index.tpl": "<body>" + text + " {{ .Content }}</body>"})
+
+		for i := 0; i < 20; i++ {
+			time.Sleep(100 * time.Millisecond)
+			if body := h.get("http://example.org/").Body.String(); strings.Contains(body, text) {
+				return body
+			}
+		}
+
+		t.Fatalf("Expecting the template to be reloaded with %q.", text)
+		return ""
+	}
+
+	// The file watcher has read the hub and the cache by now.
+	waitFor("Before")
+
+	hub := livereload.New()
+	defer hub.Close()
+
+	pages := cache.New(10)
+
+	h.EnableLiveReload(hub)
+	h.EnableCache(pages)
+
+	if body := waitFor("After"); !strings.Contains(body, string(livereload.Script)) {
+		t.Fatalf("Expecting the live reload script in %q.", body)
+	}
+
+	if pages.Len() != 1 {
+		t.Fatalf("Expecting a cached page, got %d entries.", pages.Len())
+	}
+}
+
+func TestConditionalGet(t *testing.T) {
+	h := newTestHost(t, map[string]string{
+		"index.md":      "# Home\n",
+		"docs/intro.md": "# Intro\n",
+		"site.yaml":     "cache_control:\n  - path: \"/\"\n    value: \"public, max-age=60\"\n",
+	})
+	defer h.Close()
+
+	url := "http://example.org/"
+
+	rec := h.get(url)
+	etag, modified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if rec.Code != 200 || etag == "" || modified == "" {
+		t.Fatalf("Expecting validators, got %d %q %q.", rec.Code, etag, modified)
+	}
+	if value := rec.Header().Get("Cache-Control"); value != "public, max-age=60" {
+		t.Fatalf("Unexpected Cache-Control %q.", value)
+	}
+
+	if rec = h.get(url, "If-None-Match", etag); rec.Code != 304 || rec.Body.Len() != 0 {
+		t.Fatalf("Expecting 304, got %d.", rec.Code)
+	}
+	if rec = h.get(url, "If-Modified-Since", modified); rec.Code != 304 {
+		t.Fatalf("Expecting 304, got %d.", rec.Code)
+	}
+	if rec = h.get(url, "If-None-Match", `W/"other"`); rec.Code != 200 {
+		t.Fatalf("Expecting 200, got %d.", rec.Code)
+	}
+
+	time.Sleep(time.Second)
+
+	h.write(map[string]string{"index.md": "# Changed\n"})
+
+	if rec = h.get(url, "If-None-Match", etag); rec.Code != 200 || rec.Header().Get("ETag") == etag {
+		t.Fatalf("Expecting a new version, got %d.", rec.Code)
+	}
+
+	// Menus list the pages of subdirectories too.
+	etag = rec.Header().Get("ETag")
+
+	h.write(map[string]string{"docs/setup.md": "# Setup\n"})
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(h.root, "docs"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec = h.get(url, "If-None-Match", etag); rec.Code != 200 || rec.Header().Get("ETag") == etag {
+		t.Fatalf("Expecting a new version after adding a page to a subdirectory, got %d.", rec.Code)
+	}
+}
+
+func TestPrecompressed(t *testing.T) {
+	files := map[string]string{
+		"index.md":             "# Home\n",
+		"webroot/style.css":    "body { color: red; }",
+		"webroot/style.css.gz": "gzipped",
+	}
+
+	h := newTestHost(t, files)
+	defer h.Close()
+
+	url := "http://example.org/style.css"
+
+	rec := h.get(url, "Accept-Encoding", "gzip, deflate")
+	if rec.Body.String() != "gzipped" || rec.Header().Get("Content-Encoding") != "gzip" || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/css") {
+		t.Fatalf("Expecting the gzipped file, got %q %v.", rec.Body.String(), rec.Header())
+	}
+
+	rec = h.get(url, "Accept-Encoding", "deflate")
+	if rec.Body.String() != files["webroot/style.css"] || rec.Header().Get("Content-Encoding") != "" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Expecting the plain file, got %q %v.", rec.Body.String(), rec.Header())
+	}
+}
+
+func TestPrecompressedTraversal(t *testing.T) {
+	// The secret is outside of both the content and the webroot directories.
+	h := newTestHost(t, map[string]string{
+		"content/index.md": "# Home\n",
+		"webroot/site.css": "body {}",
+		"secret.txt":       "secret",
+		"secret.txt.gz":    "gzipped secret",
+	})
+	defer h.Close()
+
+	for _, accept := range []string{"gzip", ""} {
+		rec := h.get("http://example.org/../secret.txt", "Accept-Encoding", accept)
+		if rec.Code != http.StatusBadRequest || strings.Contains(rec.Body.String(), "secret") {
+			t.Fatalf("%q: expecting 400, got %d %q.", accept, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v1/slow" {
+			time.Sleep(500 * time.Millisecond)
+		}
+		fmt.Fprintf(w, "%s?%s %s %s %s %s", req.URL.Path, req.URL.RawQuery, req.Header.Get("X-Forwarded-Host"), req.Header.Get("X-Forwarded-Proto"), req.Header.Get("X-Forwarded-Prefix"), req.Header.Get("X-Forwarded-For"))
+	}))
+	defer backend.Close()
+
+	gone := httptest.NewServer(http.NotFoundHandler())
+	gone.Close()
+
+	h := newTestHost(t, map[string]string{
+		"index.md": "# Home\n",
+		"api.md":   "# Not the API\n",
+		"site.yaml": "proxy:\n" +
+			"  - { path: \"/api/\", url: \"" + backend.URL + "/v1/\", timeout: \"100ms\" }\n" +
+			"  - { path: \"/api/gone\", url: \"" + gone.URL + "\" }\n",
+	})
+	defer h.Close()
+
+	rec := h.get("http://example.org/api/users?page=2")
+	if expected := "/v1/users?page=2 example.org http /api 192.0.2.1"; rec.Code != 200 || rec.Body.String() != expected {
+		t.Fatalf("Expecting %q, got %d %q.", expected, rec.Code, rec.Body.String())
+	}
+
+	if rec = h.get("http://example.org/api"); !strings.HasPrefix(rec.Body.String(), "/v1/?") {
+		t.Fatalf("Unexpected backend path %q.", rec.Body.String())
+	}
+
+	if rec = h.get("http://example.org/apis"); rec.Code != 404 {
+		t.Fatalf("Expecting a page outside of the mount, got %d.", rec.Code)
+	}
+
+	if rec = h.get("http://example.org/api/slow"); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Expecting 504, got %d.", rec.Code)
+	}
+
+	if rec = h.get("http://example.org/api/gone/x"); rec.Code != http.StatusBadGateway {
+		t.Fatalf("Expecting 502, got %d.", rec.Code)
+	}
+}
+
+func TestRelativeLink(t *testing.T) {
+	pages := map[string]bool{"/": true, "/about": true, "/docs/": true, "/docs/intro": true}
+
+	root := &Host{Name: "default"}
+
+	tests := []struct {
+		page   string
+		link   string
+		result string
+	}{
+		{"/", "/about", "about/index.html"},
+		{"/", "/docs/", "docs/index.html"},
+		{"/", "//localhost/about", "about/index.html"},
+		{"/docs/", "intro", "intro/index.html"},
+		{"/docs/", "/", "../index.html"},
+		{"/docs/intro", "/about", "../../about/index.html"},
+		{"/docs/intro", "../about#team", "../../about/index.html#team"},
+		{"/docs/intro", "/css/style.css?v=1", "../../css/style.css?v=1"},
+		{"/docs/intro", "https://example.com/about", "https://example.com/about"},
+		{"/docs/intro", "//example.com/about", "//example.com/about"},
+		{"/docs/intro", "mailto:admin@example.org", "mailto:admin@example.org"},
+		{"/docs/intro", "#top", "#top"},
+	}
+
+	for _, test := range tests {
+		if result := root.relativeLink(test.page, test.link, pages); result != test.result {
+			t.Fatalf("Expecting %q on %s to become %q, got %q.", test.link, test.page, test.result, result)
+		}
+	}
+
+	// Hosts on a path only rewrite links below it.
+	docs := &Host{Name: "example.org/docs", Path: "/docs"}
+	pages = map[string]bool{"/": true, "/intro": true}
+
+	tests = []struct {
+		page   string
+		link   string
+		result string
+	}{
+		{"/intro", "/docs/", "../index.html"},
+		{"/intro", "/docs/intro", "index.html"},
+		{"/intro", "//example.org/docs/", "../index.html"},
+		{"/", "intro", "intro/index.html"},
+		{"/intro", "/about", "/about"},
+		{"/intro", "/documents", "/documents"},
+	}
+
+	for _, test := range tests {
+		if result := docs.relativeLink(test.page, test.link, pages); result != test.result {
+			t.Fatalf("Expecting %q on %s to become %q, got %q.", test.link, test.page, test.result, result)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	h := newTestHost(t, map[string]string{
+		"content/index.md":      "# Home\n",
+		"content/about.md":      "# About\n",
+		"content/docs/index.md": "# Docs\n",
+		"content/docs/intro.md": "# Intro\n\n[About](/about)\n",
+		"includes/nav.html":     "Before",
+		"templates/index.tpl":   `{{ include "includes/nav.html" }}{{ .Content }}`,
+		"webroot/css/style.css": "body {}",
+	})
+	defer h.Close()
+
+	out := filepath.Join(h.root, "build")
+
+	build := func(rendered, skipped, copied int) {
+		stats, err := h.Build(out, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Rendered != rendered || stats.Skipped != skipped || stats.Copied != copied {
+			t.Fatalf("Expecting %d rendered, %d skipped and %d copied, got %+v.", rendered, skipped, copied, *stats)
+		}
+	}
+
+	read := func(name string) string {
+		buf, err := ioutil.ReadFile(filepath.Join(out, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+
+	build(4, 0, 1)
+
+	for _, name := range []string{"index.html", "about/index.html", "docs/index.html", "docs/intro/index.html"} {
+		if body := read(name); !strings.Contains(body, "Before") {
+			t.Fatalf("Expecting the included file in %s, got %q.", name, body)
+		}
+	}
+
+	if body := read("docs/intro/index.html"); !strings.Contains(body, `href="../../about/index.html"`) {
+		t.Fatalf("Expecting a relative link, got %q.", body)
+	}
+
+	if body := read("css/style.css"); body != "body {}" {
+		t.Fatalf("Expecting the webroot to be copied, got %q.", body)
+	}
+
+	// Nothing changed.
+	build(0, 4, 0)
+
+	// Only the changed page is rendered again. Times are moved forward instead
+	// of waiting for a coarse modification time to change.
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(h.root, "content/about.md"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	build(1, 3, 0)
+
+	// Every page includes the changed file.
+	h.write(map[string]string{"includes/nav.html": "After"})
+	future = future.Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(h.root, "includes/nav.html"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	build(4, 0, 0)
+
+	if body := read("docs/index.html"); !strings.Contains(body, "After") {
+		t.Fatalf("Expecting the changed include, got %q.", body)
+	}
+
+	// Without a manifest includes are unknown.
+	if err := os.Remove(filepath.Join(out, buildManifestName)); err != nil {
+		t.Fatal(err)
+	}
+
+	build(4, 0, 0)
+}
+
+func TestLint(t *testing.T) {
+	site := map[string]string{
+		"content/about.md":        "# About\n",
+		"webroot/images/logo.png": "png",
+	}
+
+	tests := []struct {
+		name  string
+		index string
+		rule  string
+		line  int
+	}{
+		{"valid", "# Home\n\n[About](/about) [Top](#home) [Out](https://example.com/)\n\n![Logo](/images/logo.png)\n", "", 0},
+		{"broken link", "# Home\n\n[Missing](/missing)\n", LintBrokenLink, 3},
+		{"broken anchor", "# Home\n\n[Top](#nowhere)\n", LintBrokenAnchor, 3},
+		{"missing asset", "# Home\n\n![Logo](/images/missing.png)\n", LintMissingAsset, 3},
+		{"image alt", "# Home\n\n![](/images/logo.png)\n", LintImageAlt, 3},
+		{"duplicate anchor", "# Home\n\n## Setup\n\n## Setup\n", LintDuplicateAnchor, 5},
+		{"heading level", "# Home\n\n### Details\n", LintHeadingLevel, 3},
+	}
+
+	for _, test := range tests {
+		h := newTestHost(t, site)
+		h.write(map[string]string{"content/index.md": test.index})
+
+		issues, err := h.Lint()
+		h.Close()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if test.rule == "" {
+			if len(issues) > 0 {
+				t.Fatalf("%s: expecting no issues, got %v.", test.name, issues)
+			}
+			continue
+		}
+
+		if len(issues) != 1 {
+			t.Fatalf("%s: expecting one issue, got %v.", test.name, issues)
+		}
+
+		issue := issues[0]
+
+		if issue.Rule != test.rule || issue.Line != test.line || issue.URL != "/" || !strings.HasSuffix(issue.File, "index.md") {
+			t.Fatalf("%s: expecting [%s] on line %d, got %v.", test.name, test.rule, test.line, issue)
+		}
+	}
+}
+
+func TestDefaultTheme(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		theme bool
+	}{
+		{"no templates", map[string]string{}, true},
+		{"no index.tpl", map[string]string{"templates/other.tpl": "other"}, true},
+		{"broken index.tpl", map[string]string{"templates/index.tpl": "{{ .Content "}, true},
+		{"index.tpl", map[string]string{"templates/index.tpl": "custom {{ .Content }}"}, false},
+	}
+
+	for _, test := range tests {
+		files := map[string]string{
+			"site.yaml":        "page:\n  brand: \"Handbook\"\n",
+			"content/index.md": "# Welcome\n",
+		}
+		for name, text := range test.files {
+			files[name] = text
+		}
+
+		h := newTestHost(t, files)
+		body := h.get("http://example.org/").Body.String()
+		h.Close()
+
+		if !strings.Contains(body, "Welcome") {
+			t.Fatalf("%s: expecting the page content, got %q.", test.name, body)
+		}
+
+		if theme := strings.Contains(body, `<a class="brand" href="/">Handbook</a>`); theme != test.theme {
+			t.Fatalf("%s: expecting default theme to be %v, got %q.", test.name, test.theme, body)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		site   string
+		errors int
+	}{
+		{"page:\n  brand: \"Docs\"\n", 0},
+		{"proxy:\n  - path: \"/api\"\n    url: \"ftp://x\"\n", 1},
+		{"proxy:\n  - path: \"api\"\n    url: \"http://127.0.0.1:8080\"\n    timeout: -5\n", 2},
+		{"cache_control:\n  - path: \"[bad\"\n    value: \"no-cache\"\n", 1},
+		// Schema problems are reported once.
+		{"cache_control: 5\n", 1},
+	}
+
+	for _, test := range tests {
+		root, err := ioutil.TempDir("", "luminos-check")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		writeFiles(t, root, map[string]string{
+			"site.yaml":           test.site,
+			"content/index.md":    "# Home\n",
+			"templates/index.tpl": "{{ .Content }}",
+		})
+		problems := Check("default", root)
+		os.RemoveAll(root)
+
+		if problems.Errors() != test.errors {
+			t.Fatalf("Expecting %d error(s) for %q, got %v.", test.errors, test.site, problems)
+		}
+	}
+}
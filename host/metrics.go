@@ -0,0 +1,52 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"time"
+
+	"menteslibres.net/luminos/metrics"
+)
+
+var (
+	// Time spent serving requests, by host and phase: "lookup" finds the file
+	// that matches the request, "markdown" reads and converts the content of a
+	// page and "template" executes index.tpl.
+	phaseSeconds = metrics.NewHistogram(
+		"luminos_request_phase_duration_seconds",
+		"Time spent on each phase of a request.",
+		metrics.DefaultBuckets,
+		"host", "phase",
+	)
+
+	// Cache lookups, by cache and result ("hit" or "miss").
+	cacheLookups = metrics.NewCounter(
+		"luminos_cache_lookups_total",
+		"Cache lookups, the hit ratio is hits over all lookups.",
+		"cache", "result",
+	)
+)
+
+// observe records the time that was spent on a phase of a request.
+func (host *Host) observe(phase string, since time.Time) {
+	phaseSeconds.Observe(time.Since(since).Seconds(), host.Name, phase)
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"menteslibres.net/luminos/config"
+)
+
+// proxy forwards the requests below a path of the site to another service.
+type proxy struct {
+	// Path of the host, requests begin with it.
+	hostPath string
+	// Path prefix on the site, without a trailing slash.
+	prefix    string
+	target    *url.URL
+	transport *http.Transport
+	handler   *httputil.ReverseProxy
+}
+
+// byPrefix sorts proxies from the longest prefix to the shortest, so the most
+// specific mount wins.
+type byPrefix []*proxy
+
+func (p byPrefix) Len() int           { return len(p) }
+func (p byPrefix) Less(i, j int) bool { return len(p[i].prefix) > len(p[j].prefix) }
+func (p byPrefix) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// newProxies creates the proxies that site settings describe. Entries were
+// already validated.
+func (host *Host) newProxies(entries []config.ProxyConfig) []*proxy {
+	proxies := make([]*proxy, 0, len(entries))
+
+	for _, entry := range entries {
+		target, err := url.Parse(entry.URL)
+		if err != nil {
+			continue
+		}
+
+		timeout := time.Duration(entry.Timeout)
+
+		p := &proxy{
+			hostPath: host.Path,
+			prefix:   strings.TrimRight(entry.Path, "/"),
+			target:   target,
+			transport: &http.Transport{
+				DialContext: (&net.Dialer{
+					Timeout:   timeout,
+					KeepAlive: 30 * time.Second,
+				}).DialContext,
+				ResponseHeaderTimeout: timeout,
+				TLSHandshakeTimeout:   10 * time.Second,
+				IdleConnTimeout:       90 * time.Second,
+				MaxIdleConnsPerHost:   16,
+			},
+		}
+
+		p.handler = &httputil.ReverseProxy{
+			Director:  p.direct,
+			Transport: p.transport,
+			ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+				status := http.StatusBadGateway
+				if isTimeout(err) {
+					status = http.StatusGatewayTimeout
+				}
+				if !errors.Is(err, context.Canceled) {
+					host.log.Warnf("Proxy for %s failed: %q", req.URL.Path, err)
+				}
+				http.Error(w, http.StatusText(status), status)
+			},
+		}
+
+		proxies = append(proxies, p)
+	}
+
+	sort.Sort(byPrefix(proxies))
+
+	return proxies
+}
+
+// isTimeout returns true if the backend took too long to answer.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// rest returns the part of a request path that follows the prefix of the
+// proxy, and false if the path is not below the prefix.
+func (p *proxy) rest(reqpath string) (string, bool) {
+	if !strings.HasPrefix(reqpath, p.hostPath) {
+		return "", false
+	}
+	reqpath = reqpath[len(p.hostPath):]
+	if reqpath == p.prefix || strings.HasPrefix(reqpath, p.prefix+"/") {
+		return reqpath[len(p.prefix):], true
+	}
+	return "", false
+}
+
+// direct rewrites a request so it goes to the backend. The original host,
+// scheme and prefix are sent as X-Forwarded headers, X-Forwarded-For is added
+// by the reverse proxy.
+func (p *proxy) direct(req *http.Request) {
+	rest, _ := p.rest(req.URL.Path)
+
+	forwardedHost := req.Host
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	req.URL.Scheme = p.target.Scheme
+	req.URL.Host = p.target.Host
+	req.URL.Path = p.target.Path
+	if rest != "" {
+		req.URL.Path = strings.TrimRight(p.target.Path, "/") + rest
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	req.URL.RawPath = ""
+
+	if p.target.RawQuery != "" {
+		if req.URL.RawQuery == "" {
+			req.URL.RawQuery = p.target.RawQuery
+		} else {
+			req.URL.RawQuery = p.target.RawQuery + "&" + req.URL.RawQuery
+		}
+	}
+
+	req.Host = p.target.Host
+
+	req.Header.Set("X-Forwarded-Host", forwardedHost)
+	req.Header.Set("X-Forwarded-Proto", proto)
+	if prefix := p.hostPath + p.prefix; prefix != "" {
+		req.Header.Set("X-Forwarded-Prefix", prefix)
+	}
+
+	// Go would send its own user agent otherwise.
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "")
+	}
+}
+
+// proxyFor returns the proxy that serves a request, or nil if the request
+// belongs to the site.
+func (host *Host) proxyFor(req *http.Request) *proxy {
+	host.mu.RLock()
+	defer host.mu.RUnlock()
+	for _, p := range host.proxies {
+		if _, ok := p.rest(req.URL.Path); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+// setProxies replaces the proxies of the host. Idle connections to the
+// previous backends are closed, requests in flight are not affected.
+func (host *Host) setProxies(proxies []*proxy) {
+	host.mu.Lock()
+	prev := host.proxies
+	host.proxies = proxies
+	host.mu.Unlock()
+
+	for _, p := range prev {
+		p.transport.CloseIdleConnections()
+	}
+}
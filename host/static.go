@@ -0,0 +1,85 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"menteslibres.net/luminos/compress"
+)
+
+// containsDotDot returns true if a request path has ".." segments.
+func containsDotDot(p string) bool {
+	for _, segment := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// serveFile sends a file from the webroot. If a gzipped copy of the file sits
+// next to it, like style.css.gz, that copy is sent to clients that accept
+// gzip.
+func (host *Host) serveFile(w http.ResponseWriter, req *http.Request, localFile string) {
+	// http.ServeFile rejects these, but http.ServeContent does not.
+	if containsDotDot(req.URL.Path) {
+		http.Error(w, "invalid URL path", http.StatusBadRequest)
+		return
+	}
+
+	gzFile := localFile + ".gz"
+
+	stat, err := os.Stat(gzFile)
+	if err != nil || stat.IsDir() {
+		http.ServeFile(w, req, localFile)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if !compress.Accepts(req, compress.Gzip) {
+		http.ServeFile(w, req, localFile)
+		return
+	}
+
+	f, err := os.Open(gzFile)
+	if err != nil {
+		http.ServeFile(w, req, localFile)
+		return
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(localFile))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Encoding", compress.Gzip)
+
+	http.ServeContent(w, req, localFile, stat.ModTime(), f)
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+// defaultTemplate is the index.tpl used by sites that do not have one. Styles
+// are inlined so the theme works without a webroot.
+const defaultTemplate = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>{{ if .Title }}{{ .Title }}{{ if setting "page/head/title" }} &middot; {{ setting "page/head/title" }}{{ end }}{{ else }}{{ setting "page/head/title" }}{{ end }}</title>
+    <style>
+      * { box-sizing: border-box; }
+      body {
+        margin: 0;
+        font: 16px/1.6 -apple-system, BlinkMacSystemFont, "Segoe UI", Helvetica, Arial, sans-serif;
+        color: #333;
+        background: #fff;
+      }
+      a { color: #268bd2; text-decoration: none; }
+      a:hover { text-decoration: underline; }
+      .layout { display: flex; min-height: 100vh; }
+      .sidebar {
+        flex: 0 0 16rem;
+        padding: 2rem 1.5rem;
+        background: #f6f8fa;
+        border-right: 1px solid #e1e4e8;
+      }
+      .sidebar .brand { display: block; margin-bottom: 1.5rem; font-size: 1.25rem; font-weight: 600; color: #333; }
+      .sidebar a.item { display: block; padding: .2rem 0; }
+      .content { flex: 1; max-width: 48rem; padding: 2rem 3rem; }
+      .breadcrumb { margin: 0 0 1rem; padding: 0; list-style: none; font-size: .9rem; }
+      .breadcrumb li { display: inline; }
+      .breadcrumb li + li:before { content: "/"; padding: 0 .4rem; color: #999; }
+      pre, code { font-family: SFMono-Regular, Consolas, Menlo, monospace; font-size: .9em; background: #f6f8fa; }
+      pre { padding: 1rem; overflow: auto; border-radius: 3px; }
+      code { padding: .1em .3em; border-radius: 3px; }
+      pre code { padding: 0; }
+      img { max-width: 100%; }
+      table { border-collapse: collapse; }
+      th, td { padding: .4rem .8rem; border: 1px solid #e1e4e8; }
+      blockquote { margin: 0; padding: 0 1rem; color: #666; border-left: 4px solid #e1e4e8; }
+      @media (max-width: 48rem) {
+        .layout { display: block; }
+        .sidebar { border-right: none; border-bottom: 1px solid #e1e4e8; }
+        .content { padding: 1.5rem; }
+      }
+    </style>
+  </head>
+  <body>
+    <div class="layout">
+      <nav class="sidebar">
+        <a class="brand" href="{{ asset "/" }}">{{ if setting "page/brand" }}{{ setting "page/brand" }}{{ else }}Home{{ end }}</a>
+        {{ range .Menu }}
+          <a class="item" href="{{ asset .URL }}">{{ .Text }}</a>
+        {{ end }}
+      </nav>
+      <main class="content">
+        {{ if not .IsHome }}
+          {{ if .BreadCrumb }}
+            <ul class="breadcrumb">
+              {{ range .BreadCrumb }}
+                <li><a href="{{ asset .URL }}">{{ .Text }}</a></li>
+              {{ end }}
+            </ul>
+          {{ end }}
+        {{ end }}
+        {{ if .Content }}
+          {{ .ContentHeader }}
+          {{ .Content }}
+          {{ .ContentFooter }}
+        {{ else }}
+          {{ if .CurrentPage }}
+            <h1>{{ .CurrentPage.Text }}</h1>
+          {{ end }}
+          <ul>
+            {{ range .SideMenu }}
+              <li><a href="{{ asset .URL }}">{{ .Text }}</a></li>
+            {{ end }}
+          </ul>
+        {{ end }}
+      </main>
+    </div>
+  </body>
+</html>
+`
@@ -0,0 +1,150 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package host
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// validators identify a version of a rendered page.
+type validators struct {
+	etag     string
+	modified time.Time
+}
+
+// pageValidators computes the validators of a page from its source files,
+// without rendering it. The ETag changes whenever the content, header, footer,
+// menu directories, templates or site settings change, or when the page would
+// be rendered for a different host name. Menu directories are the same ones
+// incremental builds look at. Files that templates include are not taken into
+// account.
+func (host *Host) pageValidators(req *http.Request, localFile string, stat os.FileInfo) validators {
+	var v validators
+
+	h := fnv.New64a()
+	io.WriteString(h, req.Host+"\x00"+req.URL.Path+"\x00")
+
+	if host.liveReloadHub() != nil {
+		io.WriteString(h, "livereload\x00")
+	}
+
+	add := func(name string, stat os.FileInfo) {
+		if stat == nil {
+			return
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00", name, stat.Size(), stat.ModTime().UnixNano())
+		if stat.ModTime().After(v.modified) {
+			v.modified = stat.ModTime()
+		}
+	}
+
+	addFile := func(name string) {
+		stat, _ := os.Stat(name)
+		add(name, stat)
+	}
+
+	dir := localFile
+	if stat.IsDir() == false {
+		dir = path.Dir(localFile)
+	}
+	dir = strings.TrimRight(dir, pathSeparator) + pathSeparator
+
+	// Menus list the page directory, its parent and its subdirectories.
+	for _, name := range dependencies(localFile, dir) {
+		addFile(name)
+	}
+
+	addFile(host.siteFile())
+
+	if host.TemplateRoot != "" {
+		files, _ := ioutil.ReadDir(host.TemplateRoot)
+		for _, fp := range files {
+			add(host.TemplateRoot+pathSeparator+fp.Name(), fp)
+		}
+	} else {
+		io.WriteString(h, "default\x00")
+	}
+
+	v.etag = fmt.Sprintf(`W/"%x"`, h.Sum64())
+
+	return v
+}
+
+// notModified returns true if the client already has the page identified by
+// the given validators. If-None-Match takes precedence over
+// If-Modified-Since.
+func (v validators) notModified(req *http.Request) bool {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return false
+	}
+
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, v.etag)
+	}
+
+	if ims := req.Header.Get("If-Modified-Since"); ims != "" && !v.modified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return v.modified.Truncate(time.Second).After(t) == false
+	}
+
+	return false
+}
+
+// setHeaders adds the validators to a response.
+func (v validators) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("ETag", v.etag)
+	if !v.modified.IsZero() {
+		w.Header().Set("Last-Modified", v.modified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// etagMatch uses the weak comparison function on a list of entity tags.
+func etagMatch(list string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setCacheControl sets the Cache-Control header of a response according to
+// the cache_control rules of the site settings. The path is relative to the
+// host.
+func (host *Host) setCacheControl(w http.ResponseWriter, reqpath string) {
+	if value := host.siteConfig().CacheControlFor("/" + strings.TrimLeft(reqpath, "/")); value != "" {
+		w.Header().Set("Cache-Control", value)
+	}
+}
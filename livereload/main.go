@@ -0,0 +1,144 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package livereload notifies browsers about file changes using Server-Sent
+// Events, so pages can reload themselves while editing a site.
+package livereload
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Path is the URL of the event stream, it's handled before virtual host
+// routing.
+const Path = "/_luminos/livereload"
+
+// How often a comment is sent to keep idle connections open.
+const keepAlive = time.Second * 15
+
+// Script is injected into rendered pages, it reloads the page when a change
+// event arrives.
+const Script = template.HTML(`<script>(function() {
+  if (!window.EventSource) { return; }
+  var source = new EventSource("` + Path + `");
+  source.addEventListener("reload", function() {
+    source.close();
+    window.location.reload();
+  });
+})();</script>`)
+
+// Hub keeps track of connected browsers and sends them change notifications.
+type Hub struct {
+	mu      sync.Mutex
+	clients map[chan string]bool
+	closed  chan struct{}
+}
+
+// New creates a hub.
+func New() *Hub {
+	return &Hub{
+		clients: make(map[chan string]bool),
+		closed:  make(chan struct{}),
+	}
+}
+
+// Notify tells all connected browsers that the given file has changed.
+func (h *Hub) Notify(file string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.clients {
+		select {
+		case ch <- file:
+		default:
+			// A notification is already pending for this client.
+		}
+	}
+}
+
+// Close disconnects all browsers, they are not going to be notified anymore.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	select {
+	case <-h.closed:
+	default:
+		close(h.closed)
+	}
+}
+
+func (h *Hub) subscribe() chan string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ch := make(chan string, 1)
+	h.clients[ch] = true
+
+	return ch
+}
+
+func (h *Hub) unsubscribe(ch chan string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, ch)
+}
+
+// ServeHTTP streams change notifications to a browser.
+func (h *Hub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ch := h.subscribe()
+	defer h.unsubscribe(ch)
+
+	fmt.Fprintf(w, "retry: 1000\n\n")
+	flusher.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case file := <-ch:
+			fmt.Fprintf(w, "event: reload\ndata: %s\n\n", file)
+			flusher.Flush()
+		case <-ticker.C:
+			fmt.Fprintf(w, ": ping\n\n")
+			flusher.Flush()
+		case <-req.Context().Done():
+			return
+		case <-h.closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package livereload
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvent reads lines from an event stream until a blank line.
+func readEvent(t *testing.T, r *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expecting an event, got %q.", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := New()
+
+	srv := httptest.NewServer(hub)
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Expecting an event stream, got %q.", ct)
+	}
+
+	r := bufio.NewReader(res.Body)
+
+	// The browser is subscribed once the first event arrives.
+	if lines := readEvent(t, r); len(lines) != 1 || lines[0] != "retry: 1000" {
+		t.Fatalf("Expecting a retry interval, got %q.", lines)
+	}
+
+	// Notifications do not block while one is pending.
+	done := make(chan struct{})
+	go func() {
+		hub.Notify("index.md")
+		hub.Notify("about.md")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expecting Notify not to block.")
+	}
+
+	if lines := readEvent(t, r); len(lines) != 2 || lines[0] != "event: reload" || lines[1] != "data: index.md" {
+		t.Fatalf("Expecting a reload event, got %q.", lines)
+	}
+
+	// Closing the hub ends the stream.
+	hub.Close()
+	hub.Close()
+
+	for {
+		if _, err := r.ReadString('\n'); err != nil {
+			break
+		}
+	}
+}
+
+func TestScript(t *testing.T) {
+	if !strings.Contains(string(Script), `new EventSource("`+Path+`")`) {
+		t.Fatalf("Expecting the script to listen to %s, got %q.", Path, Script)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package logger writes leveled application messages as text or JSON lines.
+// Messages may carry fields, like the name of the host they are about.
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a message.
+type Level int
+
+// Message levels.
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of the level.
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given name: "debug", "info", "warn"
+// or "error".
+func ParseLevel(name string) (Level, error) {
+	name = strings.ToLower(name)
+	if name == "" {
+		return Info, nil
+	}
+	if name == "warning" {
+		name = "warn"
+	}
+	for i := range levelNames {
+		if levelNames[i] == name {
+			return Level(i), nil
+		}
+	}
+	return Info, fmt.Errorf("Unknown log level %q.", name)
+}
+
+// Format is the format of log lines.
+type Format int
+
+// Log formats.
+const (
+	Text Format = iota
+	JSON
+)
+
+// ParseFormat returns the format with the given name: "text" or "json".
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "text":
+		return Text, nil
+	case "json":
+		return JSON, nil
+	}
+	return Text, fmt.Errorf("Unknown log format %q.", name)
+}
+
+// output is shared by a logger and all the loggers derived from it.
+type output struct {
+	mu     sync.Mutex
+	w      io.Writer
+	level  Level
+	format Format
+}
+
+// field is a key and a value that is added to every message of a logger.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes messages of at least a given level. It's safe for concurrent
+// use.
+type Logger struct {
+	out    *output
+	fields []field
+}
+
+// New creates a logger that writes text messages of level Info and above to
+// w.
+func New(w io.Writer) *Logger {
+	return &Logger{out: &output{w: w, level: Info}}
+}
+
+// std is the logger used by the package functions.
+var std = New(os.Stderr)
+
+// Default returns the logger used by the package functions.
+func Default() *Logger {
+	return std
+}
+
+// With returns a logger that adds the given field to every message. Both
+// loggers share their output, level and format.
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return &Logger{out: l.out, fields: append(fields, field{key, value})}
+}
+
+// SetOutput changes the writer of the logger.
+func (l *Logger) SetOutput(w io.Writer) {
+	l.out.mu.Lock()
+	l.out.w = w
+	l.out.mu.Unlock()
+}
+
+// SetLevel changes the minimum level of the messages that are written.
+func (l *Logger) SetLevel(level Level) {
+	l.out.mu.Lock()
+	l.out.level = level
+	l.out.mu.Unlock()
+}
+
+// SetFormat changes the format of the messages.
+func (l *Logger) SetFormat(format Format) {
+	l.out.mu.Lock()
+	l.out.format = format
+	l.out.mu.Unlock()
+}
+
+// Enabled returns true if messages of the given level are written.
+func (l *Logger) Enabled(level Level) bool {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return level >= l.out.level
+}
+
+// Debugf writes a debug message.
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.write(Debug, format, args)
+}
+
+// Infof writes an informational message.
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.write(Info, format, args)
+}
+
+// Warnf writes a warning.
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.write(Warn, format, args)
+}
+
+// Errorf writes an error message.
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write(Error, format, args)
+}
+
+// write formats and writes a message, if its level is enabled.
+func (l *Logger) write(level Level, format string, args []interface{}) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+
+	if level < l.out.level {
+		return
+	}
+
+	now := time.Now()
+	message := strings.TrimRight(fmt.Sprintf(format, args...), "\n")
+
+	var buf bytes.Buffer
+
+	if l.out.format == JSON {
+		buf.WriteString(`{"time":`)
+		buf.WriteString(strconv.Quote(now.Format(time.RFC3339)))
+		buf.WriteString(`,"level":`)
+		buf.WriteString(strconv.Quote(level.String()))
+		buf.WriteString(`,"msg":`)
+		writeJSON(&buf, message)
+		for _, f := range l.fields {
+			buf.WriteString(",")
+			writeJSON(&buf, f.key)
+			buf.WriteString(":")
+			writeJSON(&buf, f.value)
+		}
+		buf.WriteString("}\n")
+	} else {
+		buf.WriteString(now.Format("2006/01/02 15:04:05 "))
+		fmt.Fprintf(&buf, "%-5s %s", strings.ToUpper(level.String()), message)
+		for _, f := range l.fields {
+			fmt.Fprintf(&buf, " %s=%s", f.key, textValue(f.value))
+		}
+		buf.WriteString("\n")
+	}
+
+	l.out.w.Write(buf.Bytes())
+}
+
+// writeJSON writes a value as JSON, values that can't be encoded are written
+// as strings.
+func writeJSON(buf *bytes.Buffer, v interface{}) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		b.Reset()
+		enc.Encode(fmt.Sprint(v))
+	}
+	buf.Write(bytes.TrimRight(b.Bytes(), "\n"))
+}
+
+// textValue formats a field value, values with spaces or quotes are quoted.
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// With returns a logger derived from the default logger with the given field.
+func With(key string, value interface{}) *Logger {
+	return std.With(key, value)
+}
+
+// Debugf writes a debug message to the default logger.
+func Debugf(format string, args ...interface{}) {
+	std.write(Debug, format, args)
+}
+
+// Infof writes an informational message to the default logger.
+func Infof(format string, args ...interface{}) {
+	std.write(Info, format, args)
+}
+
+// Warnf writes a warning to the default logger.
+func Warnf(format string, args ...interface{}) {
+	std.write(Warn, format, args)
+}
+
+// Errorf writes an error message to the default logger.
+func Errorf(format string, args ...interface{}) {
+	std.write(Error, format, args)
+}
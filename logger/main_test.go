@@ -0,0 +1,80 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestLevels(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := New(&buf)
+	l.SetLevel(Warn)
+
+	l.Debugf("debug")
+	l.Infof("info")
+	l.With("host", "example.org").Warnf("Template error in %s.", "index.tpl")
+	l.Errorf("error\n")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expecting 2 lines, got %q", lines)
+	}
+
+	if !strings.HasSuffix(lines[0], " WARN  Template error in index.tpl. host=example.org") {
+		t.Fatalf("Unexpected line: %q", lines[0])
+	}
+
+	if !strings.HasSuffix(lines[1], " ERROR error") {
+		t.Fatalf("Unexpected line: %q", lines[1])
+	}
+}
+
+func TestJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := New(&buf)
+	l.SetFormat(JSON)
+	l.With("host", "example.org").With("port", 80).Infof("Routing: %s", "default")
+
+	var v map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &v); err != nil {
+		t.Fatal(err)
+	}
+
+	if v["level"] != "info" || v["msg"] != "Routing: default" || v["host"] != "example.org" || v["port"] != float64(80) {
+		t.Fatalf("Unexpected message: %v", v)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if l, err := ParseLevel("WARNING"); err != nil || l != Warn {
+		t.Fatalf("Unexpected level: %v %v", l, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Fatal("Expecting an error.")
+	}
+}
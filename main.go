@@ -22,10 +22,10 @@
 package main // package "menteslibres.net/luminos"
 
 import (
-	"log"
 	"os"
 
 	"menteslibres.net/gosexy/cli"
+	"menteslibres.net/luminos/logger"
 )
 
 // Handy path separator.
@@ -47,7 +47,8 @@ func main() {
 
 	// Dispatches the command.
 	if err := cli.Dispatch(); err != nil {
-		log.Fatal("Could not start Luminos: ", err)
+		logger.Errorf("Could not start Luminos: %v", err)
+		os.Exit(1)
 	}
 
 }
@@ -0,0 +1,348 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package metrics keeps counters, gauges and histograms in memory and writes
+// them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are upper bounds for latencies, in seconds.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Default is the registry that metrics are added to when created.
+var Default = &Registry{}
+
+// metric is a named group of series.
+type metric interface {
+	write(w io.Writer)
+	metricName() string
+}
+
+// Registry is a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// add appends a metric to the registry. Names must be unique.
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, other := range r.metrics {
+		if other.metricName() == m.metricName() {
+			panic(fmt.Sprintf("Metric %q was already defined.", m.metricName()))
+		}
+	}
+	r.metrics = append(r.metrics, m)
+}
+
+// WriteTo writes all metrics in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].metricName() < metrics[j].metricName()
+	})
+
+	c := &countingWriter{w: w}
+	buf := bufio.NewWriter(c)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	err := buf.Flush()
+
+	return c.n, err
+}
+
+// ServeHTTP writes all metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// countingWriter counts the bytes that are written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// series is a set of values that share the same label values.
+type series struct {
+	labels []string
+	value  float64
+	// Histograms only.
+	counts []uint64
+	count  uint64
+}
+
+// family holds the series of a metric.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newFamily(name, help, kind string, labels []string) *family {
+	return &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+func (f *family) metricName() string {
+	return f.name
+}
+
+// get returns the series for the given label values, f.mu must be held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("Metric %q expects %d label values, got %d.", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values, f.mu must be held.
+func (f *family) sorted() []*series {
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	list := make([]*series, len(keys))
+	for i, key := range keys {
+		list[i] = f.series[key]
+	}
+	return list
+}
+
+// header writes the HELP and TYPE lines.
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// write writes the series of counters and gauges.
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.header(w)
+	for _, s := range f.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, labelPairs(f.labels, s.labels), formatFloat(s.value))
+	}
+}
+
+// Counter is a value that only goes up, partitioned by labels.
+type Counter struct {
+	*family
+}
+
+// NewCounter creates a counter and adds it to the default registry.
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newFamily(name, help, "counter", labels)}
+	Default.add(c)
+	return c
+}
+
+// Inc adds one to the counter with the given label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds a non-negative value to the counter with the given label values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("Counter %q can't decrease.", c.name))
+	}
+	c.mu.Lock()
+	c.get(values).value += v
+	c.mu.Unlock()
+}
+
+// Value returns the value of the counter with the given label values.
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(values).value
+}
+
+// Gauge is a value that goes up and down, partitioned by labels.
+type Gauge struct {
+	*family
+}
+
+// NewGauge creates a gauge and adds it to the default registry.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newFamily(name, help, "gauge", labels)}
+	Default.add(g)
+	return g
+}
+
+// Set sets the value of the gauge with the given label values.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.mu.Lock()
+	g.get(values).value = v
+	g.mu.Unlock()
+}
+
+// GaugeFunc is a gauge without labels that reads its value when metrics are
+// written.
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc creates a gauge that calls fn to get its value and adds it to
+// the default registry.
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	Default.add(g)
+	return g
+}
+
+func (g *GaugeFunc) metricName() string {
+	return g.name
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, escape(g.help, false))
+	fmt.Fprintf(w, "# TYPE %s gauge\n", g.name)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// Histogram counts observations in buckets, partitioned by labels.
+type Histogram struct {
+	*family
+	buckets []float64
+}
+
+// NewHistogram creates a histogram with the given bucket upper bounds, which
+// must be sorted, and adds it to the default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{newFamily(name, help, "histogram", labels), buckets}
+	Default.add(h)
+	return h
+}
+
+// Observe adds a value to the histogram with the given label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, s := range h.sorted() {
+		values := append(append([]string(nil), s.labels...), "")
+		for i, bound := range h.buckets {
+			values[len(values)-1] = formatFloat(bound)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(labels, values), s.counts[i])
+		}
+		values[len(values)-1] = "+Inf"
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labelPairs(labels, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, labelPairs(h.labels, s.labels), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labelPairs(h.labels, s.labels), s.count)
+	}
+}
+
+// labelPairs formats label names and values like {name="value",...}.
+func labelPairs(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i := range names {
+		pairs[i] = names[i] + `="` + escape(values[i], true) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escape escapes backslashes and newlines, and double quotes within label
+// values.
+func escape(s string, quotes bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quotes {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+// formatFloat formats a sample value.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests.", "host", "status")
+	c.Inc("example.org", "200")
+	c.Inc("example.org", "200")
+	c.Add(3, `a"b`, "404")
+
+	h := NewHistogram("test_duration_seconds", "Latency.", []float64{0.1, 1}, "phase")
+	h.Observe(0.05, "render")
+	h.Observe(0.5, "render")
+	h.Observe(2, "render")
+
+	NewGaugeFunc("test_hosts", "Hosts.", func() float64 { return 2 })
+
+	var buf bytes.Buffer
+	if _, err := Default.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"# TYPE test_requests_total counter",
+		`test_requests_total{host="a\"b",status="404"} 3`,
+		`test_requests_total{host="example.org",status="200"} 2`,
+		"# TYPE test_duration_seconds histogram",
+		`test_duration_seconds_bucket{phase="render",le="0.1"} 1`,
+		`test_duration_seconds_bucket{phase="render",le="1"} 2`,
+		`test_duration_seconds_bucket{phase="render",le="+Inf"} 3`,
+		`test_duration_seconds_sum{phase="render"} 2.55`,
+		`test_duration_seconds_count{phase="render"} 3`,
+		"test_hosts 2",
+	}
+
+	out := buf.String()
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected line %q in:\n%s", line, out)
+		}
+	}
+}
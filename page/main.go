@@ -24,7 +24,6 @@ package page
 import (
 	"fmt"
 	"html/template"
-	"log"
 	"os"
 	"path"
 	"regexp"
@@ -33,6 +32,8 @@ import (
 	"strings"
 
 	"github.com/extemporalgenome/slug"
+
+	"menteslibres.net/luminos/logger"
 )
 
 var titlePattern = regexp.MustCompile(`<h([\d])>(.+)</h[\d]>`)
@@ -348,7 +349,7 @@ func (p *Page) GetTitlesFromLevel(ll int) []anchor {
 func (p *Page) URLMatch(s string) bool {
 	re, err := regexp.Compile(s)
 	if err != nil {
-		log.Printf("URLMatch: %q", err)
+		logger.Warnf("URLMatch: %q", err)
 		return false
 	}
 	return re.MatchString(p.CurrentPage.URL)
@@ -0,0 +1,254 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package router provides a routing table for virtual hosts. Routes are
+// matched by host name, port and path prefix; the most specific route wins.
+package router
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// Name of the route that is used when no other route matches.
+const Default = "default"
+
+// Route is an entry of the routing table.
+type Route struct {
+	// Pattern as written on the settings file.
+	Pattern string
+	// Host name, it may begin with "*." to match any subdomain. An empty host
+	// matches any host.
+	Host string
+	// Port number, empty matches any port.
+	Port string
+	// Path prefix, without trailing slash. Empty matches any path.
+	Path string
+	// Pattern of the route this route is an alias of, empty if this is not an
+	// alias.
+	AliasOf string
+	// Value the route points to.
+	Value interface{}
+}
+
+// Table is a routing table. Routes are kept sorted by precedence.
+type Table struct {
+	routes   routeList
+	fallback *Route
+	patterns map[string]*Route
+}
+
+// routeList is a list of routes sorted by precedence.
+type routeList []*Route
+
+func (l routeList) Len() int {
+	return len(l)
+}
+
+func (l routeList) Less(i, j int) bool {
+	return l[i].precedes(l[j])
+}
+
+func (l routeList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+// Parse creates a route from a pattern like "example.org", "*.example.org",
+// "example.org:8080/docs" or "/docs".
+func Parse(pattern string) (*Route, error) {
+	r := &Route{Pattern: pattern}
+
+	hostport, path := pattern, ""
+	if i := strings.Index(pattern, "/"); i > -1 {
+		hostport, path = pattern[:i], pattern[i:]
+	}
+
+	r.Host = strings.ToLower(hostport)
+
+	if i := strings.LastIndex(hostport, ":"); i > -1 && !strings.HasSuffix(hostport, "]") {
+		var err error
+		if r.Host, r.Port, err = net.SplitHostPort(hostport); err != nil {
+			return nil, fmt.Errorf("Invalid route %q: %q", pattern, err)
+		}
+		r.Host = strings.ToLower(r.Host)
+		if r.Port == "" {
+			return nil, fmt.Errorf("Invalid route %q: missing port.", pattern)
+		}
+	}
+
+	r.Host = strings.Trim(r.Host, "[]")
+
+	if r.Host == "*" {
+		r.Host = ""
+	}
+
+	if strings.Contains(strings.TrimPrefix(r.Host, "*."), "*") {
+		return nil, fmt.Errorf("Invalid route %q: wildcards are only allowed as the first label.", pattern)
+	}
+
+	r.Path = strings.TrimRight(path, "/")
+
+	return r, nil
+}
+
+// IsWildcard returns true if the route matches subdomains.
+func (r *Route) IsWildcard() bool {
+	return strings.HasPrefix(r.Host, "*.")
+}
+
+// hostRank returns 2 for exact host names, 1 for wildcards and 0 for routes
+// that match any host.
+func (r *Route) hostRank() int {
+	switch {
+	case r.Host == "":
+		return 0
+	case r.IsWildcard():
+		return 1
+	}
+	return 2
+}
+
+// precedes returns true if r must be tried before o. Exact hosts come first,
+// then wildcards (longest suffix first) and then routes for any host. Among
+// routes with the same host, the longest path wins, and a route with a port
+// beats one without it.
+func (r *Route) precedes(o *Route) bool {
+	if r.hostRank() != o.hostRank() {
+		return r.hostRank() > o.hostRank()
+	}
+	if len(r.Host) != len(o.Host) {
+		return len(r.Host) > len(o.Host)
+	}
+	if len(r.Path) != len(o.Path) {
+		return len(r.Path) > len(o.Path)
+	}
+	if (r.Port == "") != (o.Port == "") {
+		return r.Port != ""
+	}
+	return r.Pattern < o.Pattern
+}
+
+// Match returns true if the route matches the given host, port and path.
+func (r *Route) Match(host string, port string, path string) bool {
+	switch {
+	case r.Host == "":
+	case r.IsWildcard():
+		suffix := r.Host[1:]
+		if len(host) <= len(suffix) || !strings.HasSuffix(host, suffix) {
+			return false
+		}
+	case r.Host != host:
+		return false
+	}
+
+	if r.Port != "" && r.Port != port {
+		return false
+	}
+
+	if r.Path == "" || path == r.Path {
+		return true
+	}
+
+	return strings.HasPrefix(path, r.Path+"/")
+}
+
+// New creates an empty routing table.
+func New() *Table {
+	return &Table{
+		patterns: make(map[string]*Route),
+	}
+}
+
+// Add creates a route for the given pattern that points to value.
+func (t *Table) Add(pattern string, value interface{}) (*Route, error) {
+	if _, ok := t.patterns[pattern]; ok {
+		return nil, fmt.Errorf("Route %q was already defined.", pattern)
+	}
+
+	if pattern == Default {
+		t.fallback = &Route{Pattern: pattern, Value: value}
+		t.patterns[pattern] = t.fallback
+		return t.fallback, nil
+	}
+
+	r, err := Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Value = value
+
+	t.patterns[pattern] = r
+	t.routes = append(t.routes, r)
+	sort.Sort(t.routes)
+
+	return r, nil
+}
+
+// Alias creates a route for another host name that points to the same value
+// and path as the given route. The alias must be a host name, optionally
+// followed by a port.
+func (t *Table) Alias(alias string, of *Route) (*Route, error) {
+	if strings.Contains(alias, "/") {
+		return nil, fmt.Errorf("Alias %q must not contain a path.", alias)
+	}
+	if of == t.fallback {
+		return nil, errors.New("The default route cannot have aliases.")
+	}
+
+	r, err := t.Add(alias+of.Path, of.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	r.AliasOf = of.Pattern
+
+	return r, nil
+}
+
+// Match returns the first route that matches the given host, port and path,
+// or the default route if none matched. It returns nil if there is no default
+// route either.
+func (t *Table) Match(host string, port string, path string) *Route {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	for _, r := range t.routes {
+		if r.Match(host, port, path) {
+			return r
+		}
+	}
+
+	return t.fallback
+}
+
+// Routes returns all routes sorted by precedence, the default route, if any,
+// is the last one.
+func (t *Table) Routes() []*Route {
+	routes := make([]*Route, 0, len(t.routes)+1)
+	routes = append(routes, t.routes...)
+	if t.fallback != nil {
+		routes = append(routes, t.fallback)
+	}
+	return routes
+}
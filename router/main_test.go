@@ -0,0 +1,107 @@
+package router
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	table := New()
+
+	patterns := []string{
+		"default",
+		"example.org",
+		"example.org/docs",
+		"example.org/docs/api",
+		"*.example.org",
+		"*.dev.example.org",
+		"example.org:8080",
+		"/static",
+	}
+
+	for _, pattern := range patterns {
+		if _, err := table.Add(pattern, pattern); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		host, port, path string
+		expected         string
+	}{
+		{"example.org", "80", "/", "example.org"},
+		{"example.org", "80", "/docs", "example.org/docs"},
+		{"example.org", "80", "/docs/", "example.org/docs"},
+		{"example.org", "80", "/docs/api/v1", "example.org/docs/api"},
+		{"example.org", "80", "/docsx", "example.org"},
+		{"EXAMPLE.org.", "80", "/docs", "example.org/docs"},
+		{"example.org", "8080", "/", "example.org:8080"},
+		{"example.org", "8080", "/docs", "example.org/docs"},
+		{"www.example.org", "80", "/docs", "*.example.org"},
+		{"a.dev.example.org", "80", "/", "*.dev.example.org"},
+		{"dev.example.org", "80", "/", "*.example.org"},
+		{"other.org", "80", "/static/logo.svg", "/static"},
+		{"other.org", "80", "/", "default"},
+	}
+
+	for i := 0; i < 10; i++ {
+		for _, test := range tests {
+			r := table.Match(test.host, test.port, test.path)
+			if r == nil {
+				t.Fatalf("%s:%s%s: expecting %q, got nil", test.host, test.port, test.path, test.expected)
+			}
+			if r.Value.(string) != test.expected {
+				t.Fatalf("%s:%s%s: expecting %q, got %q", test.host, test.port, test.path, test.expected, r.Value)
+			}
+		}
+	}
+}
+
+func TestAlias(t *testing.T) {
+	table := New()
+
+	r, err := table.Add("example.org/docs", "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = table.Alias("www.example.org", r); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = table.Alias("example.com/docs", r); err == nil {
+		t.Fatal("Expecting an error for an alias with a path.")
+	}
+
+	m := table.Match("www.example.org", "80", "/docs/intro")
+	if m == nil || m.Value.(string) != "docs" || m.AliasOf != "example.org/docs" {
+		t.Fatalf("Unexpected route %v", m)
+	}
+
+	if m = table.Match("www.example.org", "80", "/"); m != nil {
+		t.Fatalf("Expecting no match, got %v", m)
+	}
+}
+
+func TestParse(t *testing.T) {
+	bad := []string{
+		"example.org:",
+		"foo.*.example.org",
+		"*example.org",
+	}
+
+	for _, pattern := range bad {
+		if _, err := Parse(pattern); err == nil {
+			t.Fatalf("Expecting an error for %q.", pattern)
+		}
+	}
+
+	if _, err := New().Add("example.org", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	table := New()
+	table.Add("example.org", nil)
+	if _, err := table.Add("example.org", nil); err == nil {
+		t.Fatal("Expecting an error for a duplicated route.")
+	}
+}
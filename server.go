@@ -22,213 +22,324 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	//"github.com/howeyc/fsnotify"
-	"log"
+	"net"
 	"net/http"
 	"os"
-	"strings"
+	"sync/atomic"
+	"time"
 
-	"menteslibres.net/gosexy/to"
-	"menteslibres.net/gosexy/yaml"
+	"menteslibres.net/luminos/accesslog"
+	"menteslibres.net/luminos/cache"
+	"menteslibres.net/luminos/compress"
+	"menteslibres.net/luminos/config"
 	"menteslibres.net/luminos/host"
-	"menteslibres.net/luminos/watcher"
+	"menteslibres.net/luminos/livereload"
+	"menteslibres.net/luminos/logger"
+	"menteslibres.net/luminos/router"
 )
 
-// Map of hosts.
-var hosts map[string]*host.Host
+// hostTable is a snapshot of the hosts and of the routing table that points to
+// them. Snapshots are never modified, a new one replaces the current one when
+// settings are reloaded.
+type hostTable struct {
+	// Map of hosts.
+	hosts map[string]*host.Host
+	// Routing table, routes point to hosts.
+	routes *router.Table
+	// Health endpoints, nil if disabled.
+	health *config.HealthConfig
+	// Access log of the server.
+	accessLog *accessLog
+	// Access logs of hosts that have their own file.
+	hostLogs map[*host.Host]*accessLog
+	// Rendered pages of all hosts, nil if disabled.
+	pages *cache.Cache
+	// Response compression, nil if disabled.
+	compression *compress.Config
+}
+
+// Current hosts snapshot, holds a *hostTable.
+var current atomic.Value
 
-// File watcher.
-var watch *watcher.Watcher
+// Live reload hub, only set in development mode.
+var liveReload *livereload.Hub
 
 type server struct {
 }
 
 func init() {
-	// Allocating map.
-	hosts = make(map[string]*host.Host)
+	setHostTable(&hostTable{
+		hosts:  make(map[string]*host.Host),
+		routes: router.New(),
+	})
 }
 
-// Finds the appropriate hosts for a request.
-func route(req *http.Request) *host.Host {
+// currentHostTable returns the hosts snapshot that requests are routed with.
+func currentHostTable() *hostTable {
+	return current.Load().(*hostTable)
+}
 
-	// Request's hostname.
-	name := req.Host
+// setHostTable replaces the current hosts snapshot and returns the previous
+// one.
+func setHostTable(t *hostTable) *hostTable {
+	prev, _ := current.Load().(*hostTable)
+	current.Store(t)
+	return prev
+}
 
-	// Removing the port part of the host.
-	if strings.Contains(name, ":") {
-		name = name[0:strings.Index(name, ":")]
+// requestHost returns the host name and the port of a request. When the
+// request does not specify a port, the default port for the scheme is used.
+func requestHost(req *http.Request) (string, string) {
+	if name, port, err := net.SplitHostPort(req.Host); err == nil {
+		return name, port
+	}
+	if req.TLS != nil {
+		return req.Host, "443"
 	}
+	return req.Host, "80"
+}
 
-	// Host and path.
-	path := name + req.URL.Path
+// Finds the appropriate hosts for a request.
+func route(t *hostTable, req *http.Request) *host.Host {
 
-	// Searching for the host that best matches this request.
-	match := ""
+	name, port := requestHost(req)
 
-	for key := range hosts {
-		lkey := len(key)
-		if key[0] == '/' {
-			if lkey <= len(req.URL.Path) {
-				if req.URL.Path[0:lkey] == key {
-					match = key
-				}
-			}
-		}
-		if lkey <= len(path) {
-			if path[0:lkey] == key {
-				match = key
-			}
-		}
-	}
+	r := t.routes.Match(name, port, req.URL.Path)
 
-	// No host matched, let's use the default host.
-	if match == "" {
-		log.Printf("Path %v could not match any route, falling back to the default.\n", path)
-		match = "default"
-	}
-
-	// Let's verify and return the host.
-	if _, ok := hosts[match]; !ok {
+	if r == nil {
 		// Host was not found.
-		log.Printf("Request for unknown host: %s\n", req.Host)
+		logger.Warnf("Request for unknown host: %s", req.Host)
 		return nil
 	}
 
-	return hosts[match]
+	if r.Pattern == router.Default {
+		logger.Debugf("Path %v could not match any route, falling back to the default.", name+req.URL.Path)
+	}
+
+	return r.Value.(*host.Host)
 }
 
 // Routes a request and lets the host handle it.
 func (s server) ServeHTTP(wri http.ResponseWriter, req *http.Request) {
-	r := route(req)
+	if liveReload != nil && req.URL.Path == livereload.Path {
+		liveReload.ServeHTTP(wri, req)
+		return
+	}
+
+	// Health endpoints do not belong to any host.
+	if serveHealth(wri, req) {
+		return
+	}
+
+	started := time.Now()
+
+	w := accesslog.NewResponseWriter(wri)
+
+	t := currentHostTable()
+
+	// Access logs record the size of compressed responses.
+	var rw http.ResponseWriter = w
+	if t.compression != nil {
+		rw = compress.NewResponseWriter(w, req, t.compression)
+	}
+
+	r := route(t, req)
 	if r != nil {
-		r.ServeHTTP(wri, req)
+		r.ServeHTTP(rw, req)
 	} else {
-		log.Printf("Failed to serve host %s.\n", req.Host)
-		http.Error(wri, "Not found", http.StatusNotFound)
+		logger.Warnf("Failed to serve host %s.", req.Host)
+		http.Error(rw, "Not found", http.StatusNotFound)
+	}
+
+	if cw, ok := rw.(*compress.ResponseWriter); ok {
+		cw.Close()
+	}
+
+	name := ""
+	if r != nil {
+		name = r.Name
 	}
+	countRequest(name, req, w.Status)
+
+	t.logRequest(r, accesslog.NewEntry(req, w, started))
 }
 
-// Loads settings
-func loadSettings(file string) (*yaml.Yaml, error) {
+// newRoutingTable creates a routing table for the given entries, routes point
+// to the value that values returns for each entry.
+func newRoutingTable(entries config.HostList, value func(config.HostConfig) interface{}) (*router.Table, error) {
+	table := router.New()
+
+	for _, entry := range entries {
+		r, err := table.Add(entry.Name, value(entry))
+		if err != nil {
+			return nil, err
+		}
+		for _, alias := range entry.Aliases {
+			if _, err = table.Alias(alias, r); err != nil {
+				return nil, fmt.Errorf("Host %s: %q", entry.Name, err)
+			}
+		}
+	}
+
+	return table, nil
+}
 
-	var entries map[interface{}]interface{}
-	var ok bool
+// Loads settings, warnings are logged.
+func loadSettings(file string) (*config.ServerConfig, error) {
 
 	// Trying to read settings from file.
-	y, err := yaml.Open(file)
+	c, problems := config.LoadServerConfig(file)
 
-	if err != nil {
+	for _, p := range problems {
+		if p.Warning {
+			logger.Warnf("%s", p.Error())
+		}
+	}
+
+	if err := problems.Err(); err != nil {
 		return nil, err
 	}
 
-	// Loading and verifying host entries
-	if entries, ok = y.Get("hosts").(map[interface{}]interface{}); ok == false {
-		return nil, errors.New("Missing \"hosts\" entry.")
+	applyOverrides(c)
+
+	if err := loadHosts(c); err != nil {
+		return nil, err
 	}
 
+	return c, nil
+}
+
+// loadHosts creates the hosts and the routing table for the given settings
+// and replaces the current ones. If any host fails to initialize the current
+// hosts are kept, and so are the current log settings.
+func loadHosts(c *config.ServerConfig) (err error) {
+	entries := c.Hosts
+
 	h := map[string]*host.Host{}
 
+	var logs *logSettings
+	var access *accessLogs
+
+	// Hosts that were already created are not going to be used, nor are log
+	// files that were opened for them.
+	defer func() {
+		if err != nil {
+			for name := range h {
+				h[name].Close()
+			}
+			if logs != nil {
+				logs.discard()
+			}
+			if access != nil {
+				access.discard()
+			}
+		}
+	}()
+
+	if logs, err = newLogSettings(c); err != nil {
+		return err
+	}
+
+	if access, err = openAccessLogs(c); err != nil {
+		return fmt.Errorf("Could not open access log: %q", err)
+	}
+
+	// Rendered pages are not kept across reloads.
+	var pages *cache.Cache
+	if c.Server.Cache.Entries > 0 {
+		pages = cache.New(c.Server.Cache.Entries)
+	}
+
+	var compression *compress.Config
+	if z := c.Server.Compression; z != nil {
+		compression = &compress.Config{MinSize: z.MinSize, Types: z.Types}
+		if len(compression.Types) == 0 {
+			compression.Types = compress.DefaultTypes
+		}
+	}
+
 	// Populating host entries.
-	for key := range entries {
-		name := to.String(key)
-		path := to.String(entries[name])
+	for _, entry := range entries {
+		name, path := entry.Name, entry.Root
 
 		info, err := os.Stat(path)
 		if err != nil {
-			return nil, fmt.Errorf("Failed to validate host %s: %q.", name, err)
+			return fmt.Errorf("Failed to validate host %s: %q.", name, err)
 		}
 		if info.IsDir() == false {
-			return nil, fmt.Errorf("Host %s does not point to a directory.", name)
+			return fmt.Errorf("Host %s does not point to a directory.", name)
 		}
 
-		h[name], err = host.New(name, path)
+		created, err := host.New(name, path)
 
 		if err != nil {
-			return nil, fmt.Errorf("Failed to initialize host %s: %q.", name, err)
+			return fmt.Errorf("Failed to initialize host %s: %q.", name, err)
+		}
+
+		h[name] = created
+
+		if pages != nil {
+			created.EnableCache(pages)
+		}
+
+		if liveReload != nil {
+			created.EnableLiveReload(liveReload)
 		}
 	}
 
-	for name := range hosts {
-		hosts[name].Close()
+	table, err := newRoutingTable(entries, func(entry config.HostConfig) interface{} {
+		return h[entry.Name]
+	})
+
+	if err != nil {
+		return err
 	}
 
-	hosts = h
+	// Requests that are still being served by the previous hosts are not
+	// affected by closing them, only their file watchers are stopped.
+	hostLogs := make(map[*host.Host]*accessLog)
+	for name, l := range access.hosts {
+		hostLogs[h[name]] = l
+	}
 
-	if _, ok := hosts["default"]; ok == false {
-		log.Printf("Warning: default host was not provided.\n")
+	next := &hostTable{
+		hosts:       h,
+		routes:      table,
+		health:      c.Server.Health,
+		accessLog:   access.server,
+		hostLogs:    hostLogs,
+		pages:       pages,
+		compression: compression,
 	}
 
-	return y, nil
-}
+	prev := setHostTable(next)
 
-func settingsWatcher() error {
-
-	var err error
-
-	/*
-		// Watching settings file for changes.
-		// Was not properly returning events on OSX.
-		// https://github.com/howeyc/fsnotify/issues/34
-
-		watcher, err := fsnotify.NewWatcher()
-
-		if err == nil {
-			defer watcher.Close()
-
-			go func() {
-				for {
-					select {
-					case ev := <-watcher.Event:
-						if ev == nil {
-							return
-						}
-						if ev.IsModify() {
-							log.Printf("Trying to reload settings file %s...\n", ev.Name)
-							y, err := loadSettings(ev.Name)
-							if err != nil {
-								log.Printf("Error loading settings file %s: %q\n", ev.Name, err)
-							} else {
-								settings = y
-							}
-						} else if ev.IsDelete() {
-							watcher.RemoveWatch(ev.Name)
-							watcher.Watch(ev.Name)
-						}
-					case err := <-watcher.Error:
-						log.Printf("Watcher error: %q\n", err)
-					}
-				}
-			}()
-
-			watcher.Watch(settingsFile)
-		}
-	*/
-
-	// (Stupid) time based file modification watcher.
-	watch, err = watcher.New()
-
-	if err == nil {
-		go func() {
-			defer watch.Close()
-			for {
-				select {
-				case ev := <-watch.Event:
-					if ev.IsModify() {
-						y, err := loadSettings(ev.Name)
-						if err != nil {
-							log.Printf("Error loading settings file %s: %q\n", ev.Name, err)
-						} else {
-							log.Printf("Reloading settings file %s.\n", ev.Name)
-							settings = y
-						}
-					}
-				}
-			}
-		}()
+	logs.apply()
+	access.use()
+
+	for name := range prev.hosts {
+		prev.hosts[name].Close()
+	}
+
+	closeUnusedAccessLogs(next)
 
+	if _, ok := h[router.Default]; ok == false {
+		logger.Warnf("Default host was not provided.")
 	}
 
-	return err
+	return nil
+}
+
+// closeHosts closes all hosts and their file watchers.
+func closeHosts() {
+	prev := setHostTable(&hostTable{
+		hosts:  make(map[string]*host.Host),
+		routes: router.New(),
+	})
+	for name := range prev.hosts {
+		prev.hosts[name].Close()
+	}
+	closeUnusedAccessLogs(currentHostTable())
 }
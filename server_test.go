@@ -0,0 +1,435 @@
+// Copyright (c) 2012-2014 José Carlos Nieto, https://menteslibres.net/xiam
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"menteslibres.net/luminos/config"
+	"menteslibres.net/luminos/livereload"
+	"menteslibres.net/luminos/logger"
+)
+
+// testServer serves sites that live in a temporary directory, the default
+// host points to the directory itself.
+type testServer struct {
+	t      *testing.T
+	root   string
+	config *config.ServerConfig
+}
+
+// writeFiles creates or replaces files below root.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, text := range files {
+		file := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newTestServer writes files to a temporary directory and loads hosts for it.
+// Tests may change the config and reload it.
+func newTestServer(t *testing.T, files map[string]string) *testServer {
+	root, err := ioutil.TempDir("", "luminos-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(t, root, files)
+
+	s := &testServer{t: t, root: root, config: config.NewServerConfig()}
+	s.config.Hosts.Set("default", root)
+
+	if err := s.reload(); err != nil {
+		os.RemoveAll(root)
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+// reload loads hosts for the current config.
+func (s *testServer) reload() error {
+	return loadHosts(s.config)
+}
+
+// path returns the absolute name of a file of the test sites.
+func (s *testServer) path(name string) string {
+	return filepath.Join(s.root, name)
+}
+
+// write creates or replaces files of the test sites.
+func (s *testServer) write(files map[string]string) {
+	writeFiles(s.t, s.root, files)
+}
+
+// get serves a GET request, headers are given as name and value pairs.
+func (s *testServer) get(url string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", url, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	server{}.ServeHTTP(rec, req)
+	return rec
+}
+
+// Close closes the hosts and removes their files.
+func (s *testServer) Close() {
+	closeHosts()
+	os.RemoveAll(s.root)
+}
+
+func TestReloadWhileServing(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"index.md": "# Home\n",
+	})
+	defer s.Close()
+
+	s.config.Hosts.Set("example.org", s.root)
+
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if rec := s.get("http://example.org/"); rec.Code != http.StatusOK {
+					t.Errorf("Expected status 200, got %d.", rec.Code)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := s.reload(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	close(done)
+	wg.Wait()
+}
+
+func TestHealth(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"good/index.md":              "# Home\n",
+		"good/site.yaml":             "page:\n  brand: \"Home\"\n",
+		"good/templates/index.tpl":   "{{ .Content }}",
+		"broken/index.md":            "# Home\n",
+		"broken/site.yaml":           "page:\n  brand: \"Home\"\n",
+		"broken/templates/index.tpl": "{{ if }}",
+		"bare/index.md":              "# Home\n",
+	})
+	defer s.Close()
+
+	s.config.Server.Health = &config.HealthConfig{Live: config.DefaultLivePath, Ready: config.DefaultReadyPath}
+	s.config.Hosts.Set("default", s.path("good"))
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) (int, healthStatus) {
+		var status healthStatus
+		rec := s.get("http://example.org" + path)
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		return rec.Code, status
+	}
+
+	if code, status := get("/healthz"); code != http.StatusOK || status.Status != "ok" {
+		t.Fatalf("Unexpected liveness: %d %#v", code, status)
+	}
+
+	if code, status := get("/readyz"); code != http.StatusOK || !status.Hosts["default"].Ready {
+		t.Fatalf("Unexpected readiness: %d %#v", code, status)
+	}
+
+	s.config.Hosts.Set("example.org", s.path("broken"))
+	s.config.Hosts.Set("example.com", s.path("bare"))
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The broken template and the default theme used in its place.
+	code, status := get("/readyz")
+	if code != http.StatusServiceUnavailable || status.Hosts["example.org"].Ready || len(status.Hosts["example.org"].Errors) != 2 {
+		t.Fatalf("Unexpected readiness: %d %#v", code, status)
+	}
+
+	// Default settings and the default theme.
+	if status.Hosts["example.com"].Ready || len(status.Hosts["example.com"].Errors) != 2 {
+		t.Fatalf("Unexpected readiness: %d %#v", code, status)
+	}
+
+	if !status.Hosts["default"].Ready {
+		t.Fatalf("Unexpected readiness: %d %#v", code, status)
+	}
+}
+
+func TestProxyUpgrade(t *testing.T) {
+	// Echoes whatever is sent after switching protocols.
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Upgrade") != "echo" {
+			http.Error(w, "Upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		rw.Flush()
+		io.Copy(conn, rw)
+	}))
+	defer backend.Close()
+
+	s := newTestServer(t, map[string]string{
+		"index.md":  "# Home\n",
+		"site.yaml": "proxy:\n  - path: \"/ws\"\n    url: \"" + backend.URL + "\"\n",
+	})
+	defer s.Close()
+
+	s.config.Server.Compression = &config.CompressionConfig{}
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	front := httptest.NewServer(server{})
+	defer front.Close()
+
+	conn, err := net.Dial("tcp", front.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: example.org\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+
+	r := bufio.NewReader(conn)
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expecting 101, got %d.", res.StatusCode)
+	}
+
+	fmt.Fprintf(conn, "ping\n")
+
+	line, err := r.ReadString('\n')
+	if err != nil || line != "ping\n" {
+		t.Fatalf("Expecting an echo, got %q %v.", line, err)
+	}
+}
+
+func TestFailedReloadKeepsLogs(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"index.md": "# Home\n",
+	})
+	defer s.Close()
+
+	s.config.Server.Log = config.LogConfig{Level: "debug", File: s.path("app.log")}
+	s.config.Server.AccessLog = config.AccessLogConfig{File: s.path("access.log")}
+	s.config.Hosts.Set("example.org", s.path("missing"))
+
+	if err := s.reload(); err == nil {
+		t.Fatal("Expecting the reload to fail.")
+	}
+
+	if logger.Default().Enabled(logger.Debug) || appLogFile != nil {
+		t.Fatal("Log settings changed after a failed reload.")
+	}
+
+	if _, ok := accessLogFiles[s.path("access.log")]; ok {
+		t.Fatal("Access log was opened after a failed reload.")
+	}
+
+	if rec := s.get("http://example.org/"); rec.Code != http.StatusOK {
+		t.Fatalf("Expecting the previous hosts, got %d.", rec.Code)
+	}
+}
+
+func TestReload(t *testing.T) {
+	s := newTestServer(t, map[string]string{
+		"index.md":            "# Home\n",
+		"site.yaml":           "page:\n  brand: \"Before\"\n",
+		"templates/index.tpl": `{{ setting "page/brand" }}`,
+	})
+	defer s.Close()
+
+	defer func(prev *config.ServerConfig) {
+		settings = prev
+	}(settings)
+	settings = s.config
+
+	brand := func() string {
+		rec := s.get("http://example.org/")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expecting status 200, got %d.", rec.Code)
+		}
+		return strings.TrimSpace(rec.Body.String())
+	}
+
+	if b := brand(); b != "Before" {
+		t.Fatalf("Unexpected brand %q.", b)
+	}
+
+	goroutines := runtime.NumGoroutine()
+
+	// A broken site.yaml keeps the current hosts.
+	s.write(map[string]string{"site.yaml": "page: [\n"})
+	for i := 0; i < 5; i++ {
+		reload()
+	}
+
+	if b := brand(); b != "Before" {
+		t.Fatalf("Expecting the previous settings, got %q.", b)
+	}
+
+	s.write(map[string]string{"site.yaml": "page:\n  brand: \"After\"\n"})
+	for i := 0; i < 5; i++ {
+		reload()
+	}
+
+	if b := brand(); b != "After" {
+		t.Fatalf("Expecting the new settings, got %q.", b)
+	}
+
+	// Hosts that were replaced or failed to start leave nothing running.
+	for i := 0; i < 20 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Fatalf("Expecting at most %d goroutines after reloading, got %d.", goroutines, n)
+	}
+}
+
+func TestLiveReload(t *testing.T) {
+	liveReload = livereload.New()
+	defer func() {
+		liveReload.Close()
+		liveReload = nil
+	}()
+
+	s := newTestServer(t, map[string]string{
+		"content/index.md":          "# Home\n",
+		"templates/index.tpl":       "<html><BODY>{{ .Content }}</BODY></html>",
+		"plain/content/index.md":    "# Plain\n",
+		"plain/templates/index.tpl": "{{ .Content }}",
+	})
+	defer s.Close()
+
+	s.config.Hosts.Set("plain.example.org", s.path("plain"))
+
+	if err := s.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The script goes before the end of the body or, if there is none, at the
+	// end of the page.
+	if body := s.get("http://example.org/").Body.String(); !strings.HasSuffix(body, string(livereload.Script)+"</BODY></html>") {
+		t.Fatalf("Expecting the script before </BODY>, got %q.", body)
+	}
+
+	if body := s.get("http://plain.example.org/").Body.String(); !strings.HasSuffix(body, string(livereload.Script)) || strings.Count(body, "<script>") != 1 {
+		t.Fatalf("Expecting the script at the end, got %q.", body)
+	}
+
+	srv := httptest.NewServer(server{})
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + livereload.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	events := make(chan string, 10)
+
+	go func() {
+		r := bufio.NewReader(res.Body)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(events)
+				return
+			}
+			events <- strings.TrimSpace(line)
+		}
+	}()
+
+	if line := <-events; line != "retry: 1000" {
+		t.Fatalf("Expecting the event stream, got %q.", line)
+	}
+
+	// Modification times may have a resolution of one second.
+	s.write(map[string]string{"content/index.md": "# Changed\n"})
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(s.path("content/index.md"), future, future); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(10 * time.Second)
+
+	for {
+		select {
+		case line := <-events:
+			if line == "data: "+s.path("content/index.md") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expecting a reload event for the changed file.")
+		}
+	}
+}
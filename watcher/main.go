@@ -26,7 +26,12 @@ package watcher
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
+
+	"menteslibres.net/luminos/logger"
 )
 
 // Event is the struct that watches a file.
@@ -42,6 +47,11 @@ type Watcher struct {
 	Event    chan (*Event)
 	t        time.Duration
 	watching bool
+	// Directories that are watched recursively.
+	trees map[string]*tree
+	mu    sync.Mutex
+	// Closed when the watcher is closed.
+	done chan struct{}
 }
 
 // WatcherFile is the struct that handles the last known file properties.
@@ -49,6 +59,12 @@ type WatcherFile struct {
 	Filemtime time.Time
 }
 
+// tree holds the last known modification time of every file within a
+// directory.
+type tree struct {
+	files map[string]time.Time
+}
+
 // IsModify returns true if the event was a file modification, then it resets
 // the modified flag.
 func (ev *Event) IsModify() bool {
@@ -59,14 +75,38 @@ func (ev *Event) IsModify() bool {
 	return false
 }
 
-// RemoveWatch deletes a file from the watching list.
+// RemoveWatch deletes a file or a directory from the watching list.
 func (w *Watcher) RemoveWatch(file string) error {
+	w.mu.Lock()
 	delete(w.Files, file)
+	delete(w.trees, file)
+	w.mu.Unlock()
 	return nil
 }
 
-// Watch adds a file to the watching list.
+// watches returns true if the file is on the watching list, or within a
+// directory that is.
+func (w *Watcher) watches(file string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, ok := w.Files[file]; ok {
+		return true
+	}
+	for dir := range w.trees {
+		if file == dir || strings.HasPrefix(file, dir+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch adds a file to the watching list. Files that are already watched,
+// directly or within a directory, are left as they are.
 func (w *Watcher) Watch(file string) error {
+	if w.watches(file) {
+		return nil
+	}
 	stat, err := os.Stat(file)
 	if err != nil {
 		return err
@@ -74,32 +114,136 @@ func (w *Watcher) Watch(file string) error {
 	wf := &WatcherFile{
 		Filemtime: stat.ModTime(),
 	}
+	w.mu.Lock()
 	w.Files[file] = wf
+	w.mu.Unlock()
+	return nil
+}
+
+// scanTree returns the modification time of every file within a directory.
+func scanTree(dir string) map[string]time.Time {
+	files := make(map[string]time.Time)
+	filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			files[file] = info.ModTime()
+		}
+		return nil
+	})
+	return files
+}
+
+// WatchTree adds a directory to the watching list. Files that are modified,
+// created or removed anywhere within the directory produce a modification
+// event.
+func (w *Watcher) WatchTree(dir string) error {
+	stat, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !stat.IsDir() {
+		return w.Watch(dir)
+	}
+	t := &tree{files: scanTree(dir)}
+	w.mu.Lock()
+	w.trees[dir] = t
+	w.mu.Unlock()
 	return nil
 }
 
 // Check compares the last known state of a file with the current state and
-// updates modification flags, if required.
+// updates modification flags, if required. Files and directories are read
+// without holding the lock, so adding watches never waits for a scan.
 func (w *Watcher) check() {
+	var changed []string
+
+	w.mu.Lock()
+	files := make(map[string]*WatcherFile, len(w.Files))
 	for name, f := range w.Files {
-		stat, err := os.Stat(name)
-		if err == nil {
-			mtime := stat.ModTime()
-			if mtime != f.Filemtime {
-				ev := &Event{
-					Name:     name,
-					isModify: true,
-				}
-				f.Filemtime = mtime
-				w.Event <- ev
+		files[name] = f
+	}
+	trees := make(map[string]*tree, len(w.trees))
+	for dir, t := range w.trees {
+		trees[dir] = t
+	}
+	w.mu.Unlock()
+
+	mtimes := make(map[string]time.Time, len(files))
+	for name := range files {
+		if stat, err := os.Stat(name); err == nil {
+			mtimes[name] = stat.ModTime()
+		}
+	}
+
+	scanned := make(map[string]map[string]time.Time, len(trees))
+	for dir := range trees {
+		scanned[dir] = scanTree(dir)
+	}
+
+	w.mu.Lock()
+
+	// Watches that were removed or replaced during the scan are left alone.
+	for name, f := range files {
+		mtime, ok := mtimes[name]
+		if !ok || w.Files[name] != f || mtime == f.Filemtime {
+			continue
+		}
+		f.Filemtime = mtime
+		changed = append(changed, name)
+	}
+
+	for dir, t := range trees {
+		if w.trees[dir] != t {
+			continue
+		}
+		current := scanned[dir]
+		for name, mtime := range current {
+			if last, ok := t.files[name]; !ok || last != mtime {
+				changed = append(changed, name)
 			}
 		}
+		for name := range t.files {
+			if _, ok := current[name]; !ok {
+				changed = append(changed, name)
+			}
+		}
+		t.files = current
+	}
+
+	w.mu.Unlock()
+
+	// Events are sent without holding the lock, receivers may want to add or
+	// remove watches.
+	for _, name := range changed {
+		logger.Debugf("Modified: %s", name)
+		select {
+		case w.Event <- &Event{Name: name, isModify: true}:
+		case <-w.done:
+			return
+		}
 	}
 }
 
-// Close makes a watcher sleep.
+// Close stops the watcher. Events that were not received yet are dropped.
 func (w *Watcher) Close() {
-	w.watching = false
+	w.mu.Lock()
+	if w.watching {
+		w.watching = false
+		close(w.done)
+	}
+	w.mu.Unlock()
+}
+
+// Done returns a channel that is closed when the watcher is closed, so
+// receivers of events know when to stop.
+func (w *Watcher) Done() <-chan struct{} {
+	return w.done
+}
+
+// isWatching returns false after the watcher was closed.
+func (w *Watcher) isWatching() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.watching
 }
 
 // New allocates, returns a file watcher and starts the watching loop.
@@ -109,11 +253,16 @@ func New() (*Watcher, error) {
 	w.Event = make(chan *Event)
 	w.watching = true
 	w.Files = make(map[string]*WatcherFile)
+	w.trees = make(map[string]*tree)
+	w.done = make(chan struct{})
 
 	go func() {
-		for w.watching {
+		for w.isWatching() {
 			w.check()
-			time.Sleep(w.t)
+			select {
+			case <-time.After(w.t):
+			case <-w.done:
+			}
 		}
 	}()
 
@@ -2,6 +2,9 @@ package watcher
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -25,3 +28,64 @@ func TestWatch(t *testing.T) {
 
 	w.Close()
 }
+
+func TestWatchTree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luminos-watcher")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	inside := filepath.Join(dir, "index.md")
+	if err := ioutil.WriteFile(inside, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	w, _ := New()
+	defer w.Close()
+
+	if err := w.WatchTree(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files within a watched directory are not added again.
+	if err := w.Watch(inside); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.Files) != 0 {
+		t.Fatalf("Expecting no single file watches, got %v.", w.Files)
+	}
+
+	// Watches can be added while files are being checked.
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				w.Watch(filepath.Join(dir, "missing", fmt.Sprintf("%d.md", i)))
+			}
+		}
+	}()
+
+	added := filepath.Join(dir, "about.md")
+	if err := ioutil.WriteFile(added, []byte("b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	timeout := time.After(10 * time.Second)
+
+	for {
+		select {
+		case ev := <-w.Event:
+			if ev.IsModify() && ev.Name == added {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Expecting an event for the new file.")
+		}
+	}
+}